@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/coreos/go-etcd v2.0.0+incompatible // indirect
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/mock v1.4.4
 	github.com/gosuri/uilive v0.0.3 // indirect
@@ -14,8 +15,10 @@ require (
 	github.com/onsi/ginkgo v1.14.2
 	github.com/onsi/gomega v1.10.1
 	github.com/spf13/cobra v0.0.7
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.7.1
 	github.com/ugorji/go/codec v0.0.0-20181204163529-d75b2dcb6bc8 // indirect
+	go.etcd.io/bbolt v1.3.6
 	go.uber.org/zap v1.16.0
 	golang.org/x/lint v0.0.0-20200130185559-910be7a94367 // indirect
 	golang.org/x/tools v0.0.0-20200213224642-88e652f7a869 // indirect
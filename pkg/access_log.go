@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// AccessLogFormatCommon is the Common Log Format
+	AccessLogFormatCommon = "common"
+	// AccessLogFormatCombined is the Combined Log Format, it has the referer and user agent
+	AccessLogFormatCombined = "combined"
+	// AccessLogFormatJSON writes a JSON object per line
+	AccessLogFormatJSON = "json"
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry is a line of the access log
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Size      int64     `json:"size"`
+	Referer   string    `json:"referer"`
+	UserAgent string    `json:"user_agent"`
+	Latency   float64   `json:"latency_seconds"`
+}
+
+// AccessLog writes a line to the writer for each request
+func AccessLog(next http.Handler, writer io.Writer, format string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		entry := AccessLogEntry{
+			Time:      start,
+			RemoteIP:  GetClientIP(r),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Protocol:  r.Proto,
+			Status:    recorder.status,
+			Size:      recorder.size,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			Latency:   time.Since(start).Seconds(),
+		}
+		if _, err := writer.Write(FormatAccessLog(entry, format)); err != nil {
+			logger.Error("cannot write the access log", zap.Error(err))
+		}
+	})
+}
+
+// FormatAccessLog formats the entry as a line
+func FormatAccessLog(entry AccessLogEntry, format string) []byte {
+	if format == AccessLogFormatJSON {
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+
+	size := "-"
+	if entry.Size > 0 {
+		size = fmt.Sprintf("%d", entry.Size)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s", entry.RemoteIP, entry.Time.Format(clfTimeLayout),
+		entry.Method, entry.URI, entry.Protocol, entry.Status, size)
+	if format != AccessLogFormatCommon {
+		line += fmt.Sprintf(" %q %q", orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+	return []byte(line + "\n")
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// RotatingWriter writes to a file, it will be rotated when it's too large or too old.
+// The rotated files have the suffix of the rotation time, such as access.log.20200101-150405
+type RotatingWriter struct {
+	Path string
+	// MaxSize is the max bytes of a file, disabled if it's zero
+	MaxSize int64
+	// RotateInterval is the max age of a file, disabled if it's zero
+	RotateInterval time.Duration
+	// MaxBackups is the count of the rotated files to keep, all of them are kept if it's zero
+	MaxBackups int
+	// MaxAge is the retention of the rotated files, all of them are kept if it's zero
+	MaxAge time.Duration
+
+	lock     sync.Mutex
+	file     *os.File
+	size     int64
+	openTime time.Time
+}
+
+// Write writes the data to the current file, it will be rotated before writing if necessary
+func (w *RotatingWriter) Write(data []byte) (n int, err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		if err = w.open(); err != nil {
+			return
+		}
+	}
+
+	if w.shouldRotate(int64(len(data))) {
+		if err = w.rotate(); err != nil {
+			return
+		}
+	}
+
+	n, err = w.file.Write(data)
+	w.size += int64(n)
+	return
+}
+
+// Close closes the current file
+func (w *RotatingWriter) Close() (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	return
+}
+
+func (w *RotatingWriter) shouldRotate(size int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	return (w.MaxSize > 0 && w.size+size > w.MaxSize) ||
+		(w.RotateInterval > 0 && time.Since(w.openTime) >= w.RotateInterval)
+}
+
+func (w *RotatingWriter) open() (err error) {
+	if err = os.MkdirAll(filepath.Dir(w.Path), 0751); err != nil {
+		return
+	}
+	if w.file, err = os.OpenFile(w.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+
+	var info os.FileInfo
+	if info, err = w.file.Stat(); err == nil {
+		w.size = info.Size()
+		// the age of an existing file is counted from its last modification
+		w.openTime = time.Now()
+		if w.size > 0 {
+			w.openTime = info.ModTime()
+		}
+	}
+	return
+}
+
+func (w *RotatingWriter) rotate() (err error) {
+	if err = w.file.Close(); err != nil {
+		return
+	}
+	w.file = nil
+
+	backup := fmt.Sprintf("%s.%s", w.Path, time.Now().Format("20060102-150405"))
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%s.%s.%d", w.Path, time.Now().Format("20060102-150405"), i)
+	}
+	if err = os.Rename(w.Path, backup); err != nil {
+		return
+	}
+
+	w.removeBackups()
+	return w.open()
+}
+
+// removeBackups removes the rotated files which are out of the retention
+func (w *RotatingWriter) removeBackups() {
+	backups, _ := filepath.Glob(w.Path + ".*")
+	sort.Strings(backups)
+
+	for i, backup := range backups {
+		expired := w.MaxBackups > 0 && i < len(backups)-w.MaxBackups
+		if !expired && w.MaxAge > 0 {
+			if info, err := os.Stat(backup); err == nil && time.Since(info.ModTime()) > w.MaxAge {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err := os.Remove(backup); err != nil {
+				logger.Warn("cannot remove the rotated access log", zap.String("path", backup), zap.Error(err))
+			}
+		}
+	}
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
@@ -0,0 +1,134 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("AccessLog", func() {
+	var (
+		buf     *bytes.Buffer
+		handler http.Handler
+		request *http.Request
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		request = httptest.NewRequest(http.MethodGet, "/jenkins/plugins/git/4.4.5/git.hpi?provider=tsinghua", nil)
+		request.Header.Set("User-Agent", "Jenkins/2.263.1")
+	})
+
+	serve := func(format string) string {
+		handler = server.AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusMovedPermanently)
+			_, _ = w.Write([]byte("moved"))
+		}), buf, format)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		return buf.String()
+	}
+
+	It("combined", func() {
+		Expect(serve(server.AccessLogFormatCombined)).To(MatchRegexp(
+			`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+				`"GET /jenkins/plugins/git/4\.4\.5/git\.hpi\?provider=tsinghua HTTP/1\.1" 301 5 "-" "Jenkins/2\.263\.1"\n$`))
+	})
+
+	It("common", func() {
+		Expect(serve(server.AccessLogFormatCommon)).To(HaveSuffix(`"GET /jenkins/plugins/git/4.4.5/git.hpi?provider=tsinghua HTTP/1.1" 301 5
+`))
+	})
+
+	It("json", func() {
+		line := serve(server.AccessLogFormatJSON)
+		Expect(line).To(ContainSubstring(`"remote_ip":"192.0.2.1"`))
+		Expect(line).To(ContainSubstring(`"status":301`))
+		Expect(line).To(ContainSubstring(`"user_agent":"Jenkins/2.263.1"`))
+	})
+})
+
+var _ = Describe("RotatingWriter", func() {
+	var (
+		dir    string
+		writer *server.RotatingWriter
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "access-log")
+		Expect(err).NotTo(HaveOccurred())
+		writer = &server.RotatingWriter{Path: path.Join(dir, "logs", "access.log")}
+	})
+
+	AfterEach(func() {
+		Expect(writer.Close()).To(Succeed())
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	backups := func() []string {
+		files, globErr := filepath.Glob(writer.Path + ".*")
+		Expect(globErr).NotTo(HaveOccurred())
+		return files
+	}
+
+	It("without rotation", func() {
+		_, err = writer.Write([]byte("line\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Write([]byte("line\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.ReadFile(writer.Path)).To(Equal([]byte("line\nline\n")))
+		Expect(backups()).To(BeEmpty())
+	})
+
+	It("rotate by size and keep the backups", func() {
+		writer.MaxSize = 6
+		writer.MaxBackups = 2
+		for i := 0; i < 4; i++ {
+			_, err = writer.Write([]byte("line\n"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(ioutil.ReadFile(writer.Path)).To(Equal([]byte("line\n")))
+		Expect(backups()).To(HaveLen(2))
+	})
+
+	It("rotate by time", func() {
+		writer.RotateInterval = 50 * time.Millisecond
+		_, err = writer.Write([]byte("first\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		time.Sleep(100 * time.Millisecond)
+		_, err = writer.Write([]byte("second\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.ReadFile(writer.Path)).To(Equal([]byte("second\n")))
+		Expect(backups()).To(HaveLen(1))
+		Expect(ioutil.ReadFile(backups()[0])).To(Equal([]byte("first\n")))
+	})
+
+	It("remove the expired backups", func() {
+		Expect(os.MkdirAll(path.Dir(writer.Path), 0751)).To(Succeed())
+		expired := writer.Path + ".20000101-000000"
+		Expect(ioutil.WriteFile(expired, []byte("old\n"), 0644)).To(Succeed())
+		Expect(os.Chtimes(expired, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))).To(Succeed())
+
+		writer.MaxSize = 1
+		writer.MaxAge = time.Minute
+		_, err = writer.Write([]byte("first\n"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.Write([]byte("second\n"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(backups()).To(HaveLen(1))
+		Expect(backups()[0]).NotTo(Equal(expired))
+	})
+})
@@ -0,0 +1,418 @@
+package pkg
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ChecksumSource provides the sha256 checksum of the artifacts
+type ChecksumSource interface {
+	// GetChecksum returns the base64 encoded sha256 of an artifact, ok is false if it's unknown
+	GetChecksum(name, version string) (checksum string, ok bool)
+}
+
+// ArtifactCacheStats represents the statistics of the artifact cache
+type ArtifactCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Count   int   `json:"count"`
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"maxSize"`
+}
+
+// DefaultDownloadTimeout is the max time of downloading an artifact into the cache
+const DefaultDownloadTimeout = 10 * time.Minute
+
+// ArtifactCache stores the artifacts on the local disk, evicts the least recently used ones by size
+type ArtifactCache struct {
+	Dir       string
+	MaxSize   int64
+	Checksums ChecksumSource
+	// Client downloads the artifacts, http.DefaultClient is used if it is nil
+	Client *http.Client
+	// CacheUnverified caches the artifacts whose checksums are unknown, such as the old versions of the plugins.
+	// They are redirected to the source without caching by default
+	CacheUnverified bool
+
+	hits   int64
+	misses int64
+
+	lock        sync.Mutex
+	size        int64
+	lru         *list.List
+	entries     map[string]*list.Element
+	downloading map[string]chan struct{}
+}
+
+type artifactEntry struct {
+	key  string
+	size int64
+}
+
+// NewArtifactCache creates the artifact cache, and loads the existing artifacts from the directory
+func NewArtifactCache(dir string, maxSize int64, checksums ChecksumSource) (cache *ArtifactCache, err error) {
+	if err = os.MkdirAll(dir, 0751); err != nil {
+		return
+	}
+
+	cache = &ArtifactCache{
+		Dir:         dir,
+		MaxSize:     maxSize,
+		Checksums:   checksums,
+		Client:      &http.Client{Timeout: DefaultDownloadTimeout},
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+		downloading: map[string]chan struct{}{},
+	}
+
+	type existing struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []existing
+	err = filepath.Walk(dir, func(filePath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return walkErr
+		}
+
+		key, relErr := filepath.Rel(dir, filePath)
+		if relErr == nil {
+			files = append(files, existing{key: filepath.ToSlash(key), size: info.Size(), modTime: info.ModTime()})
+		}
+		return relErr
+	})
+
+	// the most recently used one is at the front
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	for _, file := range files {
+		cache.entries[file.key] = cache.lru.PushBack(&artifactEntry{key: file.key, size: file.size})
+		cache.size += file.size
+	}
+	return
+}
+
+// errUnknownChecksum means the artifact cannot be verified, so it's not cached
+var errUnknownChecksum = fmt.Errorf("the checksum is unknown")
+
+// Serve writes the artifact to the client, it will be downloaded from the source URL if it's not cached.
+// The client is redirected to the source URL if the artifact cannot be verified
+func (c *ArtifactCache) Serve(w http.ResponseWriter, r *http.Request, artifactPath, sourceURL string) (err error) {
+	key := path.Clean("/" + artifactPath)[1:]
+	if key == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var file *os.File
+	if file, err = c.open(key, sourceURL); err == errUnknownChecksum {
+		logger.Debug("redirect the unverified artifact", zap.String("artifact", key))
+		http.Redirect(w, r, sourceURL, http.StatusFound)
+		err = nil
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(fmt.Sprintf("cannot fetch %s: %v", sourceURL, err)))
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var info os.FileInfo
+	if info, err = file.Stat(); err == nil {
+		http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+	}
+	return
+}
+
+// Stats returns the statistics of the cache
+func (c *ArtifactCache) Stats() ArtifactCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return ArtifactCacheStats{
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		Count:   c.lru.Len(),
+		Size:    c.size,
+		MaxSize: c.MaxSize,
+	}
+}
+
+func (c *ArtifactCache) open(key, sourceURL string) (file *os.File, err error) {
+	for {
+		c.lock.Lock()
+		if element, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(element)
+			c.lock.Unlock()
+
+			if file, err = os.Open(c.filePath(key)); err == nil {
+				atomic.AddInt64(&c.hits, 1)
+				return
+			}
+			c.remove(key)
+			continue
+		}
+
+		// wait for the other request which is downloading the same artifact
+		if done, ok := c.downloading[key]; ok {
+			c.lock.Unlock()
+			<-done
+			continue
+		}
+
+		// the checksum source might wait for the update center, so it's not called with the lock
+		c.lock.Unlock()
+		checksum, known := c.getChecksum(key)
+		if !known && !c.CacheUnverified {
+			atomic.AddInt64(&c.misses, 1)
+			err = errUnknownChecksum
+			return
+		}
+
+		c.lock.Lock()
+		_, cached := c.entries[key]
+		if _, downloading := c.downloading[key]; cached || downloading {
+			c.lock.Unlock()
+			continue
+		}
+		done := make(chan struct{})
+		c.downloading[key] = done
+		c.lock.Unlock()
+
+		atomic.AddInt64(&c.misses, 1)
+		err = c.download(key, sourceURL, checksum)
+
+		c.lock.Lock()
+		delete(c.downloading, key)
+		close(done)
+		c.lock.Unlock()
+
+		if err != nil {
+			return
+		}
+		file, err = os.Open(c.filePath(key))
+		return
+	}
+}
+
+// download stores the artifact into the cache, it's verified if the expected checksum is not empty
+func (c *ArtifactCache) download(key, sourceURL, expected string) (err error) {
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var response *http.Response
+	if response, err = client.Get(sourceURL); err != nil {
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status code: %d", response.StatusCode)
+		return
+	}
+
+	target := c.filePath(key)
+	if err = os.MkdirAll(filepath.Dir(target), 0751); err != nil {
+		return
+	}
+
+	var tmpFile *os.File
+	if tmpFile, err = ioutil.TempFile(filepath.Dir(target), ".download-"); err != nil {
+		return
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	hash := sha256.New()
+	var size int64
+	size, err = io.Copy(io.MultiWriter(tmpFile, hash), response.Body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	if checksum := base64.StdEncoding.EncodeToString(hash.Sum(nil)); expected == "" {
+		logger.Warn("cache the unverified artifact", zap.String("artifact", key), zap.String("checksum", checksum))
+	} else if expected != checksum {
+		err = fmt.Errorf("checksum of %s does not match, expected %s, got %s", key, expected, checksum)
+		return
+	}
+
+	if err = os.Rename(tmpFile.Name(), target); err == nil {
+		c.add(key, size)
+	}
+	return
+}
+
+// getChecksum returns the expected checksum of the artifact, it's not known without the checksum source
+func (c *ArtifactCache) getChecksum(key string) (checksum string, known bool) {
+	if c.Checksums != nil {
+		name, version := parseArtifactKey(key)
+		checksum, known = c.Checksums.GetChecksum(name, version)
+	}
+	return
+}
+
+func (c *ArtifactCache) add(key string, size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = c.lru.PushFront(&artifactEntry{key: key, size: size})
+	c.size += size
+
+	// never evict the one which was just added
+	for c.MaxSize > 0 && c.size > c.MaxSize && c.lru.Len() > 1 {
+		entry := c.lru.Back().Value.(*artifactEntry)
+		c.removeEntry(entry.key)
+		_ = os.Remove(c.filePath(entry.key))
+	}
+}
+
+func (c *ArtifactCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removeEntry(key)
+}
+
+func (c *ArtifactCache) removeEntry(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.size -= element.Value.(*artifactEntry).size
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func (c *ArtifactCache) filePath(key string) string {
+	return filepath.Join(c.Dir, filepath.FromSlash(key))
+}
+
+// parseArtifactKey returns the name and version from a key, such as: plugins/git/4.4.5/git.hpi or war/2.263/jenkins.war
+func parseArtifactKey(key string) (name, version string) {
+	items := strings.Split(key, "/")
+	if len(items) != 4 && len(items) != 3 {
+		return
+	}
+
+	if items[0] == "war" && len(items) == 3 {
+		name, version = "core", items[1]
+	} else if items[0] == "plugins" && len(items) == 4 {
+		name, version = items[1], items[2]
+	}
+	return
+}
+
+// UpdateCenterChecksums provides the checksum from the update-center.json, it refreshes the data periodically.
+// The stale checksums are used until the refresh is done
+type UpdateCenterChecksums struct {
+	URL     func() string
+	Refresh time.Duration
+	// Client fetches the update center, a client with DefaultFetchTimeout is used if it's nil
+	Client *http.Client
+
+	lock         sync.Mutex
+	updateCenter *UpdateCenter
+	lastFetch    time.Time
+	fetching     chan struct{}
+}
+
+// GetChecksum returns the checksum of the plugin (or core) if the version matches with the update center
+func (u *UpdateCenterChecksums) GetChecksum(name, version string) (checksum string, ok bool) {
+	updateCenter := u.get()
+	if updateCenter == nil || name == "" {
+		return
+	}
+
+	var item UpdateCenterItem
+	if name == "core" {
+		item, ok = updateCenter.Core, true
+	} else {
+		item, ok = updateCenter.Plugins[name]
+	}
+
+	if ok = ok && item.Version == version && item.Sha256 != ""; ok {
+		checksum = item.Sha256
+	}
+	return
+}
+
+// HasPlugin tells if the plugin is in the update center.
+// It does not wait for the update center, so nothing is known before the first fetch is done
+func (u *UpdateCenterChecksums) HasPlugin(name string) (found bool) {
+	if updateCenter, _ := u.current(); updateCenter != nil {
+		_, found = updateCenter.Plugins[name]
+	}
+	return
+}
+
+func (u *UpdateCenterChecksums) get() (updateCenter *UpdateCenter) {
+	var fetching chan struct{}
+	// only wait for the first fetch, there's nothing to use before it's done
+	if updateCenter, fetching = u.current(); updateCenter == nil && fetching != nil {
+		<-fetching
+		u.lock.Lock()
+		updateCenter = u.updateCenter
+		u.lock.Unlock()
+	}
+	return
+}
+
+// current returns the loaded update center, and starts a fetch in the background if it's stale
+func (u *UpdateCenterChecksums) current() (updateCenter *UpdateCenter, fetching chan struct{}) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	updateCenter = u.updateCenter
+	fetching = u.fetching
+	if fetching == nil && (u.lastFetch.IsZero() || time.Since(u.lastFetch) > u.Refresh) {
+		fetching = make(chan struct{})
+		u.fetching = fetching
+		go u.fetch(fetching)
+	}
+	return
+}
+
+func (u *UpdateCenterChecksums) fetch(done chan struct{}) {
+	client := u.Client
+	if client == nil {
+		client = fetchClient
+	}
+	updateCenter, err := fetchUpdateCenter(client, u.URL())
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if err == nil {
+		u.updateCenter = updateCenter
+	} else {
+		logger.Warn("cannot fetch the update center for checksums", zap.Error(err))
+	}
+	// do not try again for every request when the update center is unreachable
+	u.lastFetch = time.Now()
+	u.fetching = nil
+	close(done)
+}
@@ -0,0 +1,279 @@
+package pkg_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FakeChecksums only for test
+type FakeChecksums map[string]string
+
+// GetChecksum only for test
+func (f FakeChecksums) GetChecksum(name, version string) (checksum string, ok bool) {
+	checksum, ok = f[name+"@"+version]
+	return
+}
+
+func checksumOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var _ = Describe("ArtifactCache", func() {
+	var (
+		dir       string
+		upstream  *httptest.Server
+		requests  int
+		checksums FakeChecksums
+		cache     *server.ArtifactCache
+		maxSize   int64
+		err       error
+	)
+
+	serve := func(artifactPath string) *httptest.ResponseRecorder {
+		request, reqErr := http.NewRequest(http.MethodGet, "/jenkins/"+artifactPath, nil)
+		Expect(reqErr).NotTo(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		_ = cache.Serve(recorder, request, artifactPath, upstream.URL+"/jenkins/"+artifactPath)
+		return recorder
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "artifacts")
+		Expect(err).NotTo(HaveOccurred())
+
+		requests = 0
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if strings.Contains(r.URL.Path, "missing") {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte("content of " + r.URL.Path))
+		}))
+
+		checksums = FakeChecksums{
+			"git@4.4.5":   checksumOf("content of /jenkins/plugins/git/4.4.5/git.hpi"),
+			"bad@1.0":     checksumOf("other content"),
+			"missing@1.0": checksumOf("missing"),
+			"a@1.0":       checksumOf("content of /jenkins/plugins/a/1.0/a.hpi"),
+			"b@1.0":       checksumOf("content of /jenkins/plugins/b/1.0/b.hpi"),
+			"c@1.0":       checksumOf("content of /jenkins/plugins/c/1.0/c.hpi"),
+		}
+		maxSize = 1024
+	})
+
+	JustBeforeEach(func() {
+		cache, err = server.NewArtifactCache(dir, maxSize, checksums)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should download once and serve from the disk", func() {
+		recorder := serve("plugins/git/4.4.5/git.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("content of /jenkins/plugins/git/4.4.5/git.hpi"))
+
+		recorder = serve("plugins/git/4.4.5/git.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("content of /jenkins/plugins/git/4.4.5/git.hpi"))
+
+		Expect(requests).To(Equal(1))
+		stats := cache.Stats()
+		Expect(stats.Hits).To(Equal(int64(1)))
+		Expect(stats.Misses).To(Equal(int64(1)))
+		Expect(stats.Count).To(Equal(1))
+
+		_, err = os.Stat(filepath.Join(dir, "plugins", "git", "4.4.5", "git.hpi"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should not cache the artifact which has a wrong checksum", func() {
+		recorder := serve("plugins/bad/1.0/bad.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(cache.Stats().Count).To(BeZero())
+	})
+
+	It("should give up the stalled download", func() {
+		stalled := make(chan struct{})
+		source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-stalled
+		}))
+		defer source.Close()
+		defer close(stalled)
+
+		cache.Client = &http.Client{Timeout: 100 * time.Millisecond}
+		request, reqErr := http.NewRequest(http.MethodGet, "/jenkins/plugins/git/4.4.5/git.hpi", nil)
+		Expect(reqErr).NotTo(HaveOccurred())
+		recorder := httptest.NewRecorder()
+		Expect(cache.Serve(recorder, request, "plugins/git/4.4.5/git.hpi", source.URL)).NotTo(Succeed())
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("should not cache the missing artifact", func() {
+		recorder := serve("plugins/missing/1.0/missing.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		Expect(cache.Stats().Count).To(BeZero())
+	})
+
+	It("should redirect the artifact which has an unknown checksum", func() {
+		recorder := serve("plugins/git/4.4.4/git.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusFound))
+		Expect(recorder.Header().Get("Location")).To(Equal(upstream.URL + "/jenkins/plugins/git/4.4.4/git.hpi"))
+		Expect(requests).To(BeZero())
+		Expect(cache.Stats().Count).To(BeZero())
+
+		By("cache it when it's allowed")
+		cache.CacheUnverified = true
+		recorder = serve("plugins/git/4.4.4/git.hpi")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(cache.Stats().Count).To(Equal(1))
+	})
+
+	Context("with a small size limit", func() {
+		BeforeEach(func() {
+			maxSize = 100
+		})
+
+		It("should evict the least recently used one", func() {
+			serve("plugins/a/1.0/a.hpi")
+			serve("plugins/b/1.0/b.hpi")
+			serve("plugins/a/1.0/a.hpi")
+			serve("plugins/c/1.0/c.hpi")
+
+			stats := cache.Stats()
+			Expect(stats.Count).To(Equal(2))
+			Expect(stats.Size <= maxSize).To(BeTrue())
+
+			_, err = os.Stat(filepath.Join(dir, "plugins", "b", "1.0", "b.hpi"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			_, err = os.Stat(filepath.Join(dir, "plugins", "a", "1.0", "a.hpi"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("with existing artifacts", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(dir, "plugins", "git", "4.4.5"), 0751)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "plugins", "git", "4.4.5", "git.hpi"),
+				[]byte("cached"), 0644)).To(Succeed())
+		})
+
+		It("should load them", func() {
+			Expect(cache.Stats().Count).To(Equal(1))
+			Expect(serve("plugins/git/4.4.5/git.hpi").Body.String()).To(Equal("cached"))
+			Expect(requests).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("UpdateCenterChecksums", func() {
+	var (
+		upstream  *httptest.Server
+		checksums *server.UpdateCenterChecksums
+	)
+
+	BeforeEach(func() {
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`updateCenter.post(
+{"core":{"name":"core","version":"2.263","sha256":"core-sum"},
+"plugins":{"git":{"name":"git","version":"4.4.5","sha256":"git-sum"}}}
+);`))
+		}))
+		checksums = &server.UpdateCenterChecksums{
+			URL: func() string {
+				return upstream.URL
+			},
+		}
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+	})
+
+	It("use the stale checksums while refreshing", func() {
+		var hits int32
+		release := make(chan struct{})
+		stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) > 1 {
+				<-release
+			}
+			_, _ = w.Write([]byte(`{"plugins":{"git":{"name":"git","version":"4.4.5","sha256":"git-sum"}}}`))
+		}))
+		defer stale.Close()
+		defer close(release)
+
+		checksums.URL = func() string {
+			return stale.URL
+		}
+		_, ok := checksums.GetChecksum("git", "4.4.5")
+		Expect(ok).To(BeTrue())
+
+		// the refresh is blocked by the server, but the stale checksums are still there
+		checksums.Refresh = time.Nanosecond
+		for i := 0; i < 3; i++ {
+			checksum, stillOk := checksums.GetChecksum("git", "4.4.5")
+			Expect(stillOk).To(BeTrue())
+			Expect(checksum).To(Equal("git-sum"))
+		}
+		Eventually(func() int32 {
+			return atomic.LoadInt32(&hits)
+		}).Should(Equal(int32(2)))
+	})
+
+	It("give up the stalled update center", func() {
+		stalled := make(chan struct{})
+		stalledServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-stalled
+		}))
+		defer stalledServer.Close()
+		defer close(stalled)
+
+		checksums.URL = func() string {
+			return stalledServer.URL
+		}
+		checksums.Client = &http.Client{Timeout: 100 * time.Millisecond}
+		_, ok := checksums.GetChecksum("git", "4.4.5")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("GetChecksum", func() {
+		checksum, ok := checksums.GetChecksum("git", "4.4.5")
+		Expect(ok).To(BeTrue())
+		Expect(checksum).To(Equal("git-sum"))
+
+		checksum, ok = checksums.GetChecksum("core", "2.263")
+		Expect(ok).To(BeTrue())
+		Expect(checksum).To(Equal("core-sum"))
+
+		_, ok = checksums.GetChecksum("git", "4.4.4")
+		Expect(ok).To(BeFalse())
+
+		_, ok = checksums.GetChecksum("", "")
+		Expect(ok).To(BeFalse())
+	})
+	It("HasPlugin", func() {
+		// nothing is known before the update center is fetched
+		Expect(checksums.HasPlugin("git")).To(BeFalse())
+		Eventually(func() bool {
+			return checksums.HasPlugin("git")
+		}).Should(BeTrue())
+		Expect(checksums.HasPlugin("not-exist")).To(BeFalse())
+	})
+})
@@ -0,0 +1,285 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltPluginDownloadCounter stores the data into an embedded key-value database.
+// Each year is a bucket, each plugin is a nested bucket of the year, the keys of a plugin bucket are the dates.
+// The counts of providers and Jenkins versions are stored in the nested buckets of a plugin bucket.
+type BoltPluginDownloadCounter struct {
+	db *bolt.DB
+}
+
+var (
+	providersBucket = []byte("providers")
+	versionsBucket  = []byte("versions")
+)
+
+// DefaultBoltOpenTimeout is the max time of waiting for the database which is opened by another process
+const DefaultBoltOpenTimeout = 5 * time.Second
+
+// NewBoltPluginDownloadCounter opens or creates the database file
+func NewBoltPluginDownloadCounter(dbFile string) (counter *BoltPluginDownloadCounter, err error) {
+	return OpenBoltPluginDownloadCounter(dbFile, false, DefaultBoltOpenTimeout)
+}
+
+// OpenBoltPluginDownloadCounter opens the database file, it fails if the database is still locked by another
+// process (such as the running server) after the timeout. The file must exist if it's read-only
+func OpenBoltPluginDownloadCounter(dbFile string, readOnly bool, timeout time.Duration) (
+	counter *BoltPluginDownloadCounter, err error) {
+	if readOnly {
+		// bolt creates the file even if it's read-only
+		if _, err = os.Stat(dbFile); os.IsNotExist(err) {
+			err = fmt.Errorf("cannot find the database %s", dbFile)
+			return
+		}
+	}
+
+	var db *bolt.DB
+	if db, err = bolt.Open(dbFile, 0644, &bolt.Options{Timeout: timeout, ReadOnly: readOnly}); err == nil {
+		counter = &BoltPluginDownloadCounter{db: db}
+	} else if err == bolt.ErrTimeout {
+		err = fmt.Errorf("the database %s is in use, it might be opened by the running server", dbFile)
+	}
+	return
+}
+
+// Close closes the database
+func (b *BoltPluginDownloadCounter) Close() error {
+	return b.db.Close()
+}
+
+// ReadData get all data
+func (b *BoltPluginDownloadCounter) ReadData() (dataArray []PluginDownloadData, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(year []byte, bucket *bolt.Bucket) error {
+			dataArray = append(dataArray, *readYearBucket(string(year), bucket))
+			return nil
+		})
+	})
+	return
+}
+
+// FindByYear returns the data by year
+func (b *BoltPluginDownloadCounter) FindByYear(year string) (downloadData *PluginDownloadData, err error) {
+	downloadData = &PluginDownloadData{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(year))
+		if bucket == nil {
+			// it's the same as the one of the missing data file
+			return &os.PathError{Op: "find", Path: year, Err: os.ErrNotExist}
+		}
+		downloadData = readYearBucket(year, bucket)
+		return nil
+	})
+	return
+}
+
+// Save replaces the data of a year
+func (b *BoltPluginDownloadCounter) Save(downloadData *PluginDownloadData) (err error) {
+	err = b.db.Update(func(tx *bolt.Tx) (err error) {
+		year := []byte(downloadData.Year)
+		if tx.Bucket(year) != nil {
+			if err = tx.DeleteBucket(year); err != nil {
+				return
+			}
+		}
+
+		var yearBucket, pluginBucket *bolt.Bucket
+		if yearBucket, err = tx.CreateBucket(year); err != nil {
+			return
+		}
+		for name, pluginData := range downloadData.Plugins {
+			if pluginBucket, err = yearBucket.CreateBucket([]byte(name)); err != nil {
+				return
+			}
+			if err = putCounts(pluginBucket, pluginData.Data); err != nil {
+				return
+			}
+			if err = putDimensionCounts(pluginBucket, providersBucket, pluginData.Providers); err != nil {
+				return
+			}
+			if err = putDimensionCounts(pluginBucket, versionsBucket, pluginData.Versions); err != nil {
+				return
+			}
+		}
+		return
+	})
+	return
+}
+
+func putCounts(bucket *bolt.Bucket, counts map[string]int64) (err error) {
+	for date, count := range counts {
+		if err = bucket.Put([]byte(date), encodeCount(count)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func putDimensionCounts(pluginBucket *bolt.Bucket, name []byte, dimension map[string]map[string]int64) (err error) {
+	if len(dimension) == 0 {
+		return
+	}
+
+	var dimensionBucket, bucket *bolt.Bucket
+	if dimensionBucket, err = pluginBucket.CreateBucket(name); err != nil {
+		return
+	}
+	for key, counts := range dimension {
+		if bucket, err = dimensionBucket.CreateBucket([]byte(key)); err != nil {
+			return
+		}
+		if err = putCounts(bucket, counts); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// FindPluginData returns the plugin data by searching year and name
+func (b *BoltPluginDownloadCounter) FindPluginData(year, name string) (data PluginData, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		var pluginBucket *bolt.Bucket
+		if yearBucket := tx.Bucket([]byte(year)); yearBucket != nil {
+			pluginBucket = yearBucket.Bucket([]byte(name))
+		}
+		if pluginBucket == nil {
+			return fmt.Errorf("cannot found plugin: %s", name)
+		}
+		data = readPluginBucket(pluginBucket)
+		return nil
+	})
+	return
+}
+
+// UpdateCenterCountIncrease increases the visit count of update center in the given data
+func (b *BoltPluginDownloadCounter) UpdateCenterCountIncrease(downloadData *PluginDownloadData) error {
+	return pluginCountIncrease(downloadData, "update-center")
+}
+
+// RecordPluginDownloadData increases the download count of a plugin
+func (b *BoltPluginDownloadCounter) RecordPluginDownloadData(plugin, provider string) error {
+	return b.SaveCounts([]DownloadCount{{Plugin: plugin, Provider: provider, Date: GetDate(), Count: 1}})
+}
+
+// RecordUpdateCenterVisitData increases the visit count of update center
+func (b *BoltPluginDownloadCounter) RecordUpdateCenterVisitData() error {
+	return b.SaveCounts([]DownloadCount{{Plugin: "update-center", Date: GetDate(), Count: 1}})
+}
+
+// SaveCounts adds a batch of counts in one transaction
+func (b *BoltPluginDownloadCounter) SaveCounts(counts []DownloadCount) error {
+	return b.db.Update(func(tx *bolt.Tx) (err error) {
+		for _, count := range counts {
+			if err = increaseInTx(tx, count); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+func increaseInTx(tx *bolt.Tx, count DownloadCount) (err error) {
+	var yearBucket, pluginBucket *bolt.Bucket
+	if yearBucket, err = tx.CreateBucketIfNotExists([]byte(count.Year())); err != nil {
+		return
+	}
+	if pluginBucket, err = yearBucket.CreateBucketIfNotExists([]byte(count.Plugin)); err != nil {
+		return
+	}
+	if err = increaseCount(pluginBucket, count.Date, count.Count); err != nil {
+		return
+	}
+
+	if count.Provider != "" {
+		err = increaseDimensionCount(pluginBucket, providersBucket, count.Provider, count.Date, count.Count)
+	}
+	if err == nil && count.Version != "" {
+		err = increaseDimensionCount(pluginBucket, versionsBucket, count.Version, count.Date, count.Count)
+	}
+	return
+}
+
+func increaseCount(bucket *bolt.Bucket, date string, count int64) error {
+	key := []byte(date)
+	return bucket.Put(key, encodeCount(decodeCount(bucket.Get(key))+count))
+}
+
+func increaseDimensionCount(pluginBucket *bolt.Bucket, name []byte, key, date string, count int64) (err error) {
+	var dimensionBucket, bucket *bolt.Bucket
+	if dimensionBucket, err = pluginBucket.CreateBucketIfNotExists(name); err != nil {
+		return
+	}
+	if bucket, err = dimensionBucket.CreateBucketIfNotExists([]byte(key)); err != nil {
+		return
+	}
+	return increaseCount(bucket, date, count)
+}
+
+func readYearBucket(year string, bucket *bolt.Bucket) (downloadData *PluginDownloadData) {
+	downloadData = &PluginDownloadData{
+		Year:    year,
+		Plugins: map[string]PluginData{},
+	}
+	_ = bucket.ForEach(func(name, _ []byte) error {
+		if pluginBucket := bucket.Bucket(name); pluginBucket != nil {
+			downloadData.Plugins[string(name)] = readPluginBucket(pluginBucket)
+		}
+		return nil
+	})
+	return
+}
+
+func readPluginBucket(bucket *bolt.Bucket) (data PluginData) {
+	data.Data = readCounts(bucket)
+	data.Providers = readDimensionCounts(bucket.Bucket(providersBucket))
+	data.Versions = readDimensionCounts(bucket.Bucket(versionsBucket))
+	return
+}
+
+func readCounts(bucket *bolt.Bucket) (counts map[string]int64) {
+	counts = map[string]int64{}
+	_ = bucket.ForEach(func(date, count []byte) error {
+		// the value of a nested bucket is nil
+		if count != nil {
+			counts[string(date)] = decodeCount(count)
+		}
+		return nil
+	})
+	return
+}
+
+func readDimensionCounts(dimensionBucket *bolt.Bucket) (dimension map[string]map[string]int64) {
+	if dimensionBucket == nil {
+		return
+	}
+
+	dimension = map[string]map[string]int64{}
+	_ = dimensionBucket.ForEach(func(key, _ []byte) error {
+		if bucket := dimensionBucket.Bucket(key); bucket != nil {
+			dimension[string(key)] = readCounts(bucket)
+		}
+		return nil
+	})
+	return
+}
+
+func encodeCount(count int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(count))
+	return data
+}
+
+func decodeCount(data []byte) int64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
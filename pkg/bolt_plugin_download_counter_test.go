@@ -0,0 +1,149 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+var _ = Describe("BoltPluginDownloadCounter", func() {
+	var (
+		dir     string
+		counter *server.BoltPluginDownloadCounter
+		year    string
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "bolt")
+		Expect(err).NotTo(HaveOccurred())
+		year = server.GetCurrentYear()
+
+		counter, err = server.NewBoltPluginDownloadCounter(path.Join(dir, "plugins.db"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(counter.Close()).To(Succeed())
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("the database is in use", func() {
+		_, err = server.OpenBoltPluginDownloadCounter(path.Join(dir, "plugins.db"), true, 100*time.Millisecond)
+		Expect(err).To(MatchError(ContainSubstring("is in use")))
+	})
+
+	It("open as read-only", func() {
+		Expect(counter.SaveCounts([]server.DownloadCount{{Plugin: "git", Date: "2019-01-01", Count: 1}})).To(Succeed())
+		Expect(counter.Close()).To(Succeed())
+
+		counter, err = server.OpenBoltPluginDownloadCounter(path.Join(dir, "plugins.db"), true, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		another, anotherErr := server.OpenBoltPluginDownloadCounter(path.Join(dir, "plugins.db"), true, time.Second)
+		Expect(anotherErr).NotTo(HaveOccurred())
+		Expect(another.Close()).To(Succeed())
+
+		var result *server.PluginDownloadData
+		result, err = counter.FindByYear("2019")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Plugins["git"].Data).To(Equal(map[string]int64{"2019-01-01": 1}))
+		Expect(counter.SaveCounts([]server.DownloadCount{{Plugin: "git", Date: "2019-01-01", Count: 1}})).NotTo(Succeed())
+
+		_, err = server.OpenBoltPluginDownloadCounter(path.Join(dir, "missing.db"), true, time.Second)
+		Expect(err).To(MatchError(ContainSubstring("cannot find the database")))
+	})
+
+	It("FindByYear without data", func() {
+		_, err = counter.FindByYear(year)
+		Expect(err).To(HaveOccurred())
+
+		var dataArray []server.PluginDownloadData
+		dataArray, err = counter.ReadData()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataArray).To(BeEmpty())
+	})
+
+	It("Save", func() {
+		data := &server.PluginDownloadData{
+			Year: "2019",
+			Plugins: map[string]server.PluginData{
+				"git": {Data: map[string]int64{"2019-01-01": 1}},
+			},
+		}
+		Expect(counter.Save(data)).To(Succeed())
+
+		var result *server.PluginDownloadData
+		result, err = counter.FindByYear("2019")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(data))
+
+		data.Plugins = map[string]server.PluginData{
+			"ant": {Data: map[string]int64{"2019-01-02": 2}},
+		}
+		Expect(counter.Save(data)).To(Succeed())
+		result, err = counter.FindByYear("2019")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(data))
+	})
+
+	It("SaveCounts with the provider and Jenkins version", func() {
+		Expect(counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Provider: "tsinghua", Version: "2.263.1", Date: "2019-01-01", Count: 2},
+			{Plugin: "git", Provider: "internal", Date: "2019-01-01", Count: 3},
+		})).To(Succeed())
+
+		var data server.PluginData
+		data, err = counter.FindPluginData("2019", "git")
+		Expect(err).NotTo(HaveOccurred())
+		expected := server.PluginData{
+			Data: map[string]int64{"2019-01-01": 5},
+			Providers: map[string]map[string]int64{
+				"tsinghua": {"2019-01-01": 2},
+				"internal": {"2019-01-01": 3},
+			},
+			Versions: map[string]map[string]int64{
+				"2.263.1": {"2019-01-01": 2},
+			},
+		}
+		Expect(data).To(Equal(expected))
+
+		// keep the dimensions when saving the whole year
+		var yearData *server.PluginDownloadData
+		yearData, err = counter.FindByYear("2019")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(counter.Save(yearData)).To(Succeed())
+		data, err = counter.FindPluginData("2019", "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal(expected))
+	})
+
+	It("record the data concurrently", func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				Expect(counter.RecordPluginDownloadData("git", "tsinghua")).To(Succeed())
+				Expect(counter.RecordUpdateCenterVisitData()).To(Succeed())
+			}()
+		}
+		wg.Wait()
+
+		var data server.PluginData
+		data, err = counter.FindPluginData(year, "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data[server.GetDate()]).To(Equal(int64(10)))
+
+		data, err = counter.FindPluginData(year, "update-center")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data[server.GetDate()]).To(Equal(int64(10)))
+
+		_, err = counter.FindPluginData(year, "fake")
+		Expect(err).To(HaveOccurred())
+	})
+})
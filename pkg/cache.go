@@ -3,49 +3,220 @@ package pkg
 import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
+	"time"
 )
 
 // CacheServer is the interface for saving a cache item
 type CacheServer interface {
+	// Load returns the value of a key, it's empty if the item does not exist, expired or negative
 	Load(string) string
+	// Save saves the value with the default TTL of the cache
 	Save(string, string) error
+	// LoadItem returns the item with its fetch time and TTL, the callers decide what to do with the expired one
+	LoadItem(string) (CacheItem, bool)
+	// SaveItem saves the item as it is
+	SaveItem(string, CacheItem) error
+	// List returns all the cache items
+	List() (map[string]string, error)
+	// Clear removes the given keys, or all the items if there is no key
+	Clear(...string) error
 }
 
-// FileSystemCacheServer save the cache into a filesystem
+// CacheItem is a cached value with the time it's fetched
+type CacheItem struct {
+	Value     string
+	FetchTime time.Time
+	// TTL is the time to live since the fetch time, it never expires if it's zero
+	TTL time.Duration
+	// Negative means the lookup was failed, the value is the error message
+	Negative bool
+}
+
+// ExpireTime returns the time when the item expires, it's zero if the item never expires
+func (i CacheItem) ExpireTime() (expireTime time.Time) {
+	if i.TTL > 0 {
+		expireTime = i.FetchTime.Add(i.TTL)
+	}
+	return
+}
+
+// Expired checks if the item is expired
+func (i CacheItem) Expired() bool {
+	return i.TTL > 0 && !time.Now().Before(i.ExpireTime())
+}
+
+// cacheItemYAML is the format of a cache item in the file
+type cacheItemYAML struct {
+	Value     string `yaml:"value"`
+	FetchTime string `yaml:"fetchTime,omitempty"`
+	TTL       string `yaml:"ttl,omitempty"`
+	Negative  bool   `yaml:"negative,omitempty"`
+}
+
+// MarshalYAML writes the item as a map
+func (i CacheItem) MarshalYAML() (interface{}, error) {
+	item := cacheItemYAML{Value: i.Value, Negative: i.Negative}
+	if !i.FetchTime.IsZero() {
+		item.FetchTime = i.FetchTime.Format(time.RFC3339)
+	}
+	if i.TTL > 0 {
+		item.TTL = i.TTL.String()
+	}
+	return item, nil
+}
+
+// UnmarshalYAML reads the item from a map, or a string which is written by the old versions
+func (i *CacheItem) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var value string
+	if err = unmarshal(&value); err == nil {
+		*i = CacheItem{Value: value}
+		return
+	}
+
+	var item cacheItemYAML
+	if err = unmarshal(&item); err != nil {
+		return
+	}
+	*i = CacheItem{Value: item.Value, Negative: item.Negative}
+	if item.FetchTime != "" {
+		if i.FetchTime, err = time.Parse(time.RFC3339, item.FetchTime); err != nil {
+			return
+		}
+	}
+	if item.TTL != "" {
+		i.TTL, err = time.ParseDuration(item.TTL)
+	}
+	return
+}
+
+// cacheItemsSaver saves several items at once, such as the file which is written once for all of them
+type cacheItemsSaver interface {
+	SaveItems(map[string]CacheItem) error
+}
+
+func saveCacheItems(cache CacheServer, items map[string]CacheItem) (err error) {
+	if saver, ok := cache.(cacheItemsSaver); ok {
+		return saver.SaveItems(items)
+	}
+	for key, item := range items {
+		if err = cache.SaveItem(key, item); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// FileSystemCacheServer save the cache into a filesystem, the file is read for every call.
+// So the items which are saved or removed by others are kept
 type FileSystemCacheServer struct {
 	FileName string
-
-	cache map[string]string
+	// TTL is the time to live of the saved items, they never expire if it's zero
+	TTL time.Duration
 }
 
 // Load load the key from a file
 func (c *FileSystemCacheServer) Load(key string) (val string) {
-	if err := c.parse(); err == nil {
-		val = c.cache[key]
+	if item, ok := c.LoadItem(key); ok && !item.Negative && !item.Expired() {
+		val = item.Value
 	}
 	return
 }
 
 // Save save the key into a file
 func (c *FileSystemCacheServer) Save(key string, val string) (err error) {
-	if c.cache == nil {
-		c.cache = make(map[string]string, 1)
+	return c.SaveItem(key, CacheItem{Value: val, FetchTime: time.Now(), TTL: c.TTL})
+}
+
+// LoadItem loads the item from a file
+func (c *FileSystemCacheServer) LoadItem(key string) (item CacheItem, ok bool) {
+	if cache, err := c.read(); err == nil {
+		item, ok = cache[key]
 	}
-	c.cache[key] = val
-	var data []byte
+	return
+}
 
-	if data, err = yaml.Marshal(c.cache); err == nil {
-		err = ioutil.WriteFile(c.FileName, data, 0644)
+// SaveItem saves the item into a file
+func (c *FileSystemCacheServer) SaveItem(key string, item CacheItem) (err error) {
+	return c.SaveItems(map[string]CacheItem{key: item})
+}
+
+// SaveItems saves the items into a file at once
+func (c *FileSystemCacheServer) SaveItems(items map[string]CacheItem) (err error) {
+	// the broken file is replaced
+	cache, _ := c.read()
+	for key, item := range items {
+		cache[key] = item
+	}
+	return c.write(cache)
+}
+
+// List returns all the cache items from a file, the negative ones are not included
+func (c *FileSystemCacheServer) List() (items map[string]string, err error) {
+	var cache map[string]CacheItem
+	items = map[string]string{}
+	if cache, err = c.read(); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for key, item := range cache {
+		if !item.Negative {
+			items[key] = item.Value
+		}
 	}
 	return
 }
 
-func (c *FileSystemCacheServer) parse() (err error) {
+// Clear removes the given keys from a file, or removes the file if there is no key
+func (c *FileSystemCacheServer) Clear(keys ...string) (err error) {
+	if len(keys) == 0 {
+		if err = os.Remove(c.FileName); os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	var cache map[string]CacheItem
+	if cache, err = c.read(); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	for _, key := range keys {
+		delete(cache, key)
+	}
+	return c.write(cache)
+}
+
+// read reads the items from the file, the items written by the old versions are fetched at the modified time of the file
+func (c *FileSystemCacheServer) read() (cache map[string]CacheItem, err error) {
 	var data []byte
-	c.cache = make(map[string]string, 0)
+	var info os.FileInfo
+	cache = make(map[string]CacheItem, 0)
+	if data, err = ioutil.ReadFile(c.FileName); err != nil {
+		return
+	}
+	if info, err = os.Stat(c.FileName); err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(data, cache); err != nil {
+		return
+	}
 
-	if data, err = ioutil.ReadFile(c.FileName); err == nil {
-		err = yaml.Unmarshal(data, c.cache)
+	for key, item := range cache {
+		if item.FetchTime.IsZero() {
+			item.FetchTime, item.TTL = info.ModTime(), c.TTL
+			cache[key] = item
+		}
 	}
-	return nil
+	return
+}
+
+func (c *FileSystemCacheServer) write(cache map[string]CacheItem) (err error) {
+	var data []byte
+	if data, err = yaml.Marshal(cache); err == nil {
+		err = ioutil.WriteFile(c.FileName, data, 0644)
+	}
+	return
 }
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+// latestCacheKey represents the empty version which is the key of the latest update center
+const latestCacheKey = "latest"
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of the update center URLs",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached update center URLs of each Jenkins version",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var items map[string]string
+		if items, err = serverOptions.GetCacheServer().List(); err != nil {
+			return
+		}
+
+		for _, version := range sortedKeys(items) {
+			cmd.Printf("%s\t%s\n", toCacheKeyName(version), items[version])
+		}
+		return
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [version]...",
+	Short: "Remove the cached URLs of the given Jenkins versions, or all of them",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		versions := make([]string, 0, len(args))
+		for _, arg := range args {
+			versions = append(versions, fromCacheKeyName(arg))
+		}
+		if err = serverOptions.GetCacheServer().Clear(versions...); err == nil {
+			cmd.Println("the cache is cleared")
+		}
+		return
+	},
+}
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm [version]...",
+	Short: "Resolve the update center URLs of the given Jenkins versions and cache them, the latest one by default",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		if len(args) == 0 {
+			args = []string{latestCacheKey}
+		}
+
+		cacheServer := serverOptions.GetCacheServer()
+		for _, arg := range args {
+			version := fromCacheKeyName(arg)
+
+			var targetURL *url.URL
+			if targetURL, err = serverOptions.GetURL(version); err != nil {
+				return
+			}
+			if err = cacheServer.Save(version, targetURL.String()); err != nil {
+				return
+			}
+			cmd.Printf("%s\t%s\n", arg, targetURL)
+		}
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd, cacheClearCmd, cacheWarmCmd)
+}
+
+func toCacheKeyName(version string) string {
+	if version == "" {
+		return latestCacheKey
+	}
+	return version
+}
+
+func fromCacheKeyName(name string) string {
+	if name == latestCacheKey {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CacheRefresher resolves the cached update center URLs again before they expire
+type CacheRefresher struct {
+	// Cache returns the cache server, it's called for each refresh
+	Cache func() CacheServer
+	// Resolve returns the update center URL of a Jenkins version
+	Resolve func(version string) (*url.URL, error)
+	// Interval is the interval of checking the items, the ones which expire before the next check are refreshed
+	Interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCacheRefresher creates a refresher
+func NewCacheRefresher(cache func() CacheServer, resolve func(string) (*url.URL, error), interval time.Duration) *CacheRefresher {
+	return &CacheRefresher{
+		Cache:    cache,
+		Resolve:  resolve,
+		Interval: interval,
+	}
+}
+
+// Start refreshes the items periodically until Stop is called
+func (r *CacheRefresher) Start() {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Refresh()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing
+func (r *CacheRefresher) Stop() {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+}
+
+// Refresh resolves the items which expire before the next check, the old ones are kept if it fails
+func (r *CacheRefresher) Refresh() (refreshed int) {
+	cache := r.Cache()
+	items, err := cache.List()
+	if err != nil {
+		logger.Warn("cannot list the cache items", zap.Error(err))
+		return
+	}
+
+	deadline := time.Now().Add(r.Interval)
+	for key := range items {
+		item, ok := cache.LoadItem(key)
+		if !ok || item.TTL <= 0 || item.ExpireTime().After(deadline) {
+			continue
+		}
+
+		targetURL, resolveErr := r.Resolve(key)
+		if resolveErr != nil {
+			logger.Warn("cannot refresh the update center URL", zap.String("version", key), zap.Error(resolveErr))
+			continue
+		}
+		if err = cache.Save(key, targetURL.String()); err != nil {
+			logger.Warn("cannot cache the update center URL", zap.String("version", key), zap.Error(err))
+			continue
+		}
+		refreshed++
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/url"
+	"os"
+	"time"
+)
+
+var _ = Describe("CacheRefresher", func() {
+	var (
+		cacheServer *server.FileSystemCacheServer
+		refresher   *server.CacheRefresher
+		resolved    []string
+	)
+
+	BeforeEach(func() {
+		cacheServer = &server.FileSystemCacheServer{FileName: "cache.yaml", TTL: time.Hour}
+		resolved = nil
+		refresher = server.NewCacheRefresher(func() server.CacheServer {
+			return cacheServer
+		}, func(version string) (*url.URL, error) {
+			resolved = append(resolved, version)
+			return url.Parse("https://new.com/" + version)
+		}, time.Minute)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll("cache.yaml")).To(Succeed())
+	})
+
+	It("refresh the items which are about to expire", func() {
+		Expect(cacheServer.SaveItem("2.263.1", server.CacheItem{
+			Value: "https://old.com/2.263.1", FetchTime: time.Now().Add(-time.Hour + 30*time.Second), TTL: time.Hour,
+		})).To(Succeed())
+		Expect(cacheServer.SaveItem("2.264", server.CacheItem{
+			Value: "https://old.com/2.264", FetchTime: time.Now(), TTL: time.Hour,
+		})).To(Succeed())
+		Expect(cacheServer.SaveItem("2.265", server.CacheItem{Value: "https://old.com/2.265"})).To(Succeed())
+
+		Expect(refresher.Refresh()).To(Equal(1))
+		Expect(resolved).To(Equal([]string{"2.263.1"}))
+		Expect(cacheServer.Load("2.263.1")).To(Equal("https://new.com/2.263.1"))
+		Expect(cacheServer.Load("2.264")).To(Equal("https://old.com/2.264"))
+		Expect(cacheServer.Load("2.265")).To(Equal("https://old.com/2.265"))
+	})
+})
@@ -4,7 +4,9 @@ import (
 	cache "github.com/jenkins-zh/mirror-proxy/pkg"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io/ioutil"
 	"os"
+	"time"
 )
 
 var _ = Describe("cache test", func() {
@@ -59,6 +61,71 @@ var _ = Describe("cache test", func() {
 				val := cacheServer.Load(key)
 				Expect(val).To(Equal(value))
 			})
+
+			It("list and clear cache items", func() {
+				Expect(cacheServer.Save("2.263.1", "url")).To(Succeed())
+				Expect(cacheServer.List()).To(Equal(map[string]string{key: value, "2.263.1": "url"}))
+
+				Expect(cacheServer.Clear(key)).To(Succeed())
+				Expect(cacheServer.List()).To(Equal(map[string]string{"2.263.1": "url"}))
+				Expect(cacheServer.Load(key)).To(BeEmpty())
+
+				Expect(cacheServer.Clear()).To(Succeed())
+				Expect(cacheServer.List()).To(BeEmpty())
+				// create the file again for the cleanup
+				Expect(cacheServer.Save(key, value)).To(Succeed())
+			})
+		})
+
+		Context("expiry", func() {
+			BeforeEach(func() {
+				cacheFile = "cache.yaml"
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(cacheFile)).To(Succeed())
+			})
+
+			It("load the expired and negative items", func() {
+				fetchTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+				Expect(cacheServer.SaveItem("2.263.1", cache.CacheItem{Value: "url", FetchTime: fetchTime, TTL: time.Minute})).To(Succeed())
+				Expect(cacheServer.SaveItem("2.264", cache.CacheItem{Value: "not found", FetchTime: time.Now(), TTL: time.Minute, Negative: true})).To(Succeed())
+
+				Expect(cacheServer.Load("2.263.1")).To(BeEmpty())
+				item, ok := cacheServer.LoadItem("2.263.1")
+				Expect(ok).To(BeTrue())
+				Expect(item.Expired()).To(BeTrue())
+				Expect(item.ExpireTime()).To(BeTemporally("==", fetchTime.Add(time.Minute)))
+
+				Expect(cacheServer.Load("2.264")).To(BeEmpty())
+				item, ok = cacheServer.LoadItem("2.264")
+				Expect(ok).To(BeTrue())
+				Expect(item.Negative).To(BeTrue())
+				Expect(item.Expired()).To(BeFalse())
+				Expect(cacheServer.List()).NotTo(HaveKey("2.264"))
+			})
+
+			It("the item never expires without TTL", func() {
+				item, ok := cacheServer.LoadItem(key)
+				Expect(ok).To(BeTrue())
+				Expect(item.ExpireTime().IsZero()).To(BeTrue())
+				Expect(item.Expired()).To(BeFalse())
+			})
+
+			It("read the items of the old versions", func() {
+				Expect(ioutil.WriteFile(cacheFile, []byte("\"2.263.1\": https://a.com/update-center.json\n"), 0644)).To(Succeed())
+				oldTime := time.Now().Add(-2 * time.Hour)
+				Expect(os.Chtimes(cacheFile, oldTime, oldTime)).To(Succeed())
+
+				cacheServer = &cache.FileSystemCacheServer{FileName: cacheFile, TTL: time.Hour}
+				item, ok := cacheServer.LoadItem("2.263.1")
+				Expect(ok).To(BeTrue())
+				Expect(item.Value).To(Equal("https://a.com/update-center.json"))
+				Expect(item.Expired()).To(BeTrue())
+
+				cacheServer = &cache.FileSystemCacheServer{FileName: cacheFile, TTL: 3 * time.Hour}
+				Expect(cacheServer.Load("2.263.1")).To(Equal("https://a.com/update-center.json"))
+			})
 		})
 	})
 })
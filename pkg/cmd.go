@@ -1,15 +1,21 @@
 package pkg
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/jenkins-zh/mirror-proxy/pkg/helper"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // ServerOptions represents the options for a server
@@ -27,10 +33,95 @@ type ServerOptions struct {
 	CertFile string
 	KeyFile  string
 
-	DataFilePath string
-	Printer      helper.Printer
+	// ProxyMode fetches the update center and streams it back instead of redirecting
+	ProxyMode bool
+
+	// RewriteUpdateCenter lets all the download URLs of the update center go through this server
+	RewriteUpdateCenter bool
+	// ExternalURL is the URL which the clients use to access this server
+	ExternalURL string
+	// RewriteCacheTTL is the time of caching the rewritten update centers
+	RewriteCacheTTL time.Duration
+
+	// SignCertFile and SignKeyFile are used to sign the rewritten update center
+	SignCertFile string
+	SignKeyFile  string
+
+	// CacheTTL is the time to live of the cached update center URLs, they never expire if it's zero
+	CacheTTL time.Duration
+	// CacheNegativeTTL is the time to live of the failed lookups, they are not cached if it's zero
+	CacheNegativeTTL time.Duration
+	// CacheRefreshInterval is the interval of refreshing the cached URLs before they expire, disabled if it's zero
+	CacheRefreshInterval time.Duration
+	// CacheWriteThrough writes the cached URLs into the cache file, then they are kept after restarting
+	CacheWriteThrough bool
+	// CacheType is the store of the cached URLs, the replicas could share the cache in Redis
+	CacheType string
+	CacheFile string
+
+	CacheRedisAddress   string
+	CacheRedisPassword  string
+	CacheRedisDB        int
+	CacheRedisKeyPrefix string
+	CacheRedisPoolSize  int
+
+	// Upstream is the official update center which resolves the update center URL of a Jenkins version
+	Upstream string
+	// TiersSource is a local file or a URL of the tier manifest, the URLs are resolved without the upstream if it's given
+	TiersSource   string
+	TiersInterval time.Duration
+
+	ArtifactCacheDir     string
+	ArtifactCacheMaxSize int64
+	// ArtifactCacheDownloadTimeout is the max time of downloading an artifact into the cache
+	ArtifactCacheDownloadTimeout time.Duration
+	// ArtifactCacheUnverified caches the artifacts whose checksums are not in the update center
+	ArtifactCacheUnverified bool
+
+	// LogLevel and LogFormat come from the flags or the config file: log-level, log-format
+	LogLevel  string
+	LogFormat string
+
+	// AccessLogFile is the file of the access log, disabled if it's empty
+	AccessLogFile           string
+	AccessLogFormat         string
+	AccessLogMaxSize        int64
+	AccessLogRotateInterval time.Duration
+	AccessLogMaxBackups     int
+	AccessLogMaxAge         time.Duration
 
-	WorkPool *WorkPool
+	DataFilePath string
+	CounterType  string
+	// FlushInterval is the interval of saving the aggregated download counts, they are saved immediately if it's zero
+	FlushInterval time.Duration
+	Printer       helper.Printer
+
+	// GitRemote is the repository which the download data will be pushed to, only works with the git counter
+	GitRemote       string
+	GitBranch       string
+	GitUsername     string
+	GitPassword     string
+	GitSyncInterval time.Duration
+
+	// ShutdownTimeout is the max time of waiting for the in-flight requests when the server stops
+	ShutdownTimeout time.Duration
+
+	HealthCheckInterval     time.Duration
+	HealthCheckTimeout      time.Duration
+	HealthCheckProviderPath string
+
+	WorkPool      *WorkPool
+	Counter       PluginDownloadCounter
+	Aggregator    *DownloadAggregator
+	HealthChecker *HealthChecker
+	ArtifactCache *ArtifactCache
+	Signer        *UpdateCenterSigner
+	RewriteCache  *RewrittenUpdateCenters
+	Metrics       *Metrics
+	Certificate   *CertificateStore
+	// Cache is the cache of the update center URLs, it's created once when the server starts
+	Cache CacheServer
+	Tiers *TierResolver
 }
 
 var serverOptions ServerOptions
@@ -38,6 +129,20 @@ var serverOptions ServerOptions
 var rootCmd = &cobra.Command{
 	Use:   "mirror-proxy",
 	Short: "mirror-proxy is the proxy of Jenkins Update Center",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = applyConfig(cmd.Flags())
+		return
+	},
+	// start the server without the serve command to be compatible with the existing deployments
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = serverOptions.Run(cmd, args)
+		return
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the server",
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		err = serverOptions.Run(cmd, args)
 		return
@@ -49,37 +154,136 @@ func GetRootCmd() *cobra.Command {
 	return rootCmd
 }
 
+// GetServerOptions returns the options of the commands
+func GetServerOptions() *ServerOptions {
+	return &serverOptions
+}
+
 func init() {
 	cobra.OnInitialize(func() {
 		initConfig(rootCmd)
 	})
+	rootCmd.AddCommand(serveCmd)
 
-	rootCmd.Flags().StringVar(&serverOptions.Config, "config", "", "config file (default is $HOME/.mirror-proxy.yaml)")
-	rootCmd.Flags().StringVarP(&serverOptions.DefaultProvider, "default-provider", "", "tsinghua",
+	rootCmd.PersistentFlags().StringVar(&serverOptions.Config, "config", "", "config file (default is $HOME/.mirror-proxy.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.DefaultProvider, "default-provider", "", "tsinghua",
 		"The default provider of the update center mirror")
-	rootCmd.Flags().StringVarP(&serverOptions.DefaultJSONServer, "default-json-server", "", "https://cdn.jsdelivr.net/gh/jenkins-zh/update-center-mirror",
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.DefaultJSONServer, "default-json-server", "", "https://cdn.jsdelivr.net/gh/jenkins-zh/update-center-mirror",
 		"The default JSON server of the update center mirror")
 
-	rootCmd.Flags().StringVarP(&serverOptions.Host, "host", "", "0.0.0.0",
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.Host, "host", "", "0.0.0.0",
 		"The host of the server")
-	rootCmd.Flags().IntVarP(&serverOptions.Port, "port", "", 7070,
+	rootCmd.PersistentFlags().IntVarP(&serverOptions.Port, "port", "", 7070,
 		"The port of the server")
-	rootCmd.Flags().IntVarP(&serverOptions.PortLTS, "port-lts", "", 7071,
+	rootCmd.PersistentFlags().IntVarP(&serverOptions.PortLTS, "port-lts", "", 7071,
 		"The LTS port of the server")
-	rootCmd.Flags().BoolVarP(&serverOptions.EnableLTS, "enable-lts", "", false,
+	rootCmd.PersistentFlags().BoolVarP(&serverOptions.EnableLTS, "enable-lts", "", false,
 		"If enable the lts")
 
-	rootCmd.Flags().StringVarP(&serverOptions.DataFilePath, "data-file-path", "", "data",
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.LogLevel, "log-level", "", "info",
+		"The level of the logs, supported levels: debug, info, warn, error")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.LogFormat, "log-format", "", LogFormatJSON,
+		"The format of the logs, supported formats: json, console")
+
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.AccessLogFile, "access-log", "", "",
+		"The file of the access log, disabled if it is empty")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.AccessLogFormat, "access-log-format", "", AccessLogFormatCombined,
+		"The format of the access log, supported formats: combined, common, json")
+	rootCmd.PersistentFlags().Int64VarP(&serverOptions.AccessLogMaxSize, "access-log-max-size", "", 100*1024*1024,
+		"The max size (in bytes) of the access log file before it gets rotated, disabled if it is zero")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.AccessLogRotateInterval, "access-log-rotate-interval", "", 24*time.Hour,
+		"The interval of rotating the access log file, disabled if it is zero")
+	rootCmd.PersistentFlags().IntVarP(&serverOptions.AccessLogMaxBackups, "access-log-max-backups", "", 30,
+		"The count of the rotated access log files to keep, all of them are kept if it is zero")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.AccessLogMaxAge, "access-log-max-age", "", 0,
+		"The retention of the rotated access log files, all of them are kept if it is zero")
+
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.DataFilePath, "data-file-path", "", "data",
 		"The data file path")
-	rootCmd.Flags().StringVarP(&serverOptions.CertFile, "cert", "", "",
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CounterType, "counter-type", "", CounterTypeGit,
+		"The store type of the download data, supported types: git, bolt")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.FlushInterval, "flush-interval", "", time.Minute,
+		"The interval of saving the download counts which are aggregated in memory, disabled if it is zero")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.GitRemote, "git-remote", "", "",
+		"The git repository which the download data will be pushed to, disabled if it is empty")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.GitBranch, "git-branch", "", "master",
+		"The branch of the git repository")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.GitUsername, "git-username", "", "",
+		"The username of the git repository")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.GitPassword, "git-password", "", "",
+		"The password or token of the git repository")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.GitSyncInterval, "git-sync-interval", "", 10*time.Minute,
+		"The interval of committing and pushing the download data")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CertFile, "cert", "", "",
 		"The cert file of the server")
-	rootCmd.Flags().StringVarP(&serverOptions.KeyFile, "key", "", "",
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.KeyFile, "key", "", "",
 		"The key file of the server")
 
-	viper.BindPFlag("default-provider", rootCmd.PersistentFlags().Lookup("default-provider"))
-	viper.BindPFlag("default-json-server", rootCmd.PersistentFlags().Lookup("default-json-server"))
-	viper.BindPFlag("cert", rootCmd.PersistentFlags().Lookup("cert"))
-	viper.BindPFlag("key", rootCmd.PersistentFlags().Lookup("key"))
+	rootCmd.PersistentFlags().BoolVarP(&serverOptions.ProxyMode, "proxy-mode", "", false,
+		"Fetch the update center from the provider and stream it back instead of redirecting")
+
+	rootCmd.PersistentFlags().BoolVarP(&serverOptions.RewriteUpdateCenter, "rewrite-update-center", "", false,
+		"Rewrite the download URLs of the update center, let them go through this server")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.ExternalURL, "external-url", "", "",
+		"The URL which the clients use to access this server, it is required by --rewrite-update-center")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.RewriteCacheTTL, "rewrite-cache-ttl", "", 10*time.Minute,
+		"The time of caching the rewritten update centers, they are checked by ETag for every request if it is zero")
+
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.SignCertFile, "sign-cert", "", "",
+		"The certificate chain file to sign the rewritten update center")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.SignKeyFile, "sign-key", "", "",
+		"The RSA private key file to sign the rewritten update center")
+
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.CacheTTL, "cache-ttl", "", 24*time.Hour,
+		"The time to live of the cached update center URLs, they never expire if it is zero")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.CacheNegativeTTL, "cache-negative-ttl", "", 0,
+		"The time to live of the failed lookups of the update center URLs, they are not cached if it is zero")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.CacheRefreshInterval, "cache-refresh-interval", "", 10*time.Minute,
+		"The interval of refreshing the cached update center URLs before they expire, disabled if it is zero")
+
+	rootCmd.PersistentFlags().BoolVarP(&serverOptions.CacheWriteThrough, "cache-write-through", "", true,
+		"Write the cached update center URLs into the cache file, then they are kept after restarting")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CacheType, "cache-type", "", CacheTypeFile,
+		"The store of the cached update center URLs, supported types: file, redis")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CacheFile, "cache-file", "", "cache.yaml",
+		"The file of the cached update center URLs")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CacheRedisAddress, "cache-redis-address", "", "localhost:6379",
+		"The address of the Redis server which stores the cached update center URLs")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CacheRedisPassword, "cache-redis-password", "", "",
+		"The password of the Redis server")
+	rootCmd.PersistentFlags().IntVarP(&serverOptions.CacheRedisDB, "cache-redis-db", "", 0,
+		"The database of the Redis server")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.CacheRedisKeyPrefix, "cache-redis-key-prefix", "", "mirror-proxy:cache:",
+		"The prefix of the keys in the Redis server")
+	rootCmd.PersistentFlags().IntVarP(&serverOptions.CacheRedisPoolSize, "cache-redis-pool-size", "", DefaultRedisPoolSize,
+		"The max count of the connections to the Redis server")
+
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.Upstream, "upstream", "", DefaultUpstream,
+		"The official update center which resolves the update center URL of a Jenkins version")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.TiersSource, "tiers", "", "",
+		"The tier manifest (tiers.json) of the Jenkins versions, a local file or a URL such as the one of the JSON server. "+
+			"The update center URLs are resolved from it without the upstream")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.TiersInterval, "tiers-interval", "", time.Hour,
+		"The interval of loading the tier manifest again, disabled if it is zero")
+
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.ArtifactCacheDir, "artifact-cache-dir", "", "",
+		"The directory to cache the plugin artifacts, disabled if it is empty")
+	rootCmd.PersistentFlags().Int64VarP(&serverOptions.ArtifactCacheMaxSize, "artifact-cache-max-size", "", 10*1024*1024*1024,
+		"The max size (in bytes) of the artifact cache, the least recently used ones will be evicted")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.ArtifactCacheDownloadTimeout, "artifact-cache-download-timeout", "",
+		DefaultDownloadTimeout, "The max time of downloading an artifact into the cache")
+	rootCmd.PersistentFlags().BoolVarP(&serverOptions.ArtifactCacheUnverified, "artifact-cache-unverified", "", false,
+		"Cache the artifacts whose checksums are not in the update center, they are redirected to the provider by default")
+
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.ShutdownTimeout, "shutdown-timeout", "", 30*time.Second,
+		"The max time of waiting for the in-flight requests when the server stops")
+
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.HealthCheckInterval, "health-check-interval", "", 0,
+		"The interval of checking the health of providers and JSON servers, disabled if it is zero")
+	rootCmd.PersistentFlags().DurationVarP(&serverOptions.HealthCheckTimeout, "health-check-timeout", "", 10*time.Second,
+		"The timeout of each health check request")
+	rootCmd.PersistentFlags().StringVarP(&serverOptions.HealthCheckProviderPath, "health-check-provider-path", "", "updates/update-center.json",
+		"The path of a known artifact which will be checked on each provider")
 
 	serverOptions.WorkPool = &WorkPool{}
 	serverOptions.WorkPool.InitPool(5)
@@ -101,31 +305,78 @@ func initConfig(printer helper.Printer) {
 		viper.SetConfigName(".mirror-proxy")
 	}
 
-	viper.AutomaticEnv()
+	bindFlags(rootCmd.PersistentFlags())
 
 	if err := viper.ReadInConfig(); err == nil {
 		printer.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
 
+// GetPluginDownloadCounter returns the counter of the download data
+func (o *ServerOptions) GetPluginDownloadCounter() PluginDownloadCounter {
+	if o.Counter != nil {
+		return o.Counter
+	}
+	return &GitPluginDownloadCounter{Path: o.DataFilePath}
+}
+
 // GetProviderURL get the update center URL from a provider
 func (o *ServerOptions) GetProviderURL(official *url.URL, query UpdateCenterQuery) (targetURL string) {
-	jsonServer, provider := query.JSONServer, query.Provider
-	if provider == "" {
-		provider = o.DefaultProvider
-	}
+	targetURL, _, _ = o.resolveProviderURL(official, query)
+	return
+}
+
+// getRedirectURL returns the update center URL from a provider, and counts the redirect
+func (o *ServerOptions) getRedirectURL(r *http.Request, official *url.URL, query UpdateCenterQuery) (targetURL string) {
+	fields := GetRequestFields(r)
+	targetURL, fields.Provider, fields.JSONServer = o.resolveProviderURL(official, query)
+	o.Metrics.RecordRedirect(fields.Provider, fields.JSONServer)
+	return
+}
+
+func (o *ServerOptions) resolveProviderURL(official *url.URL, query UpdateCenterQuery) (targetURL, provider, jsonServer string) {
+	jsonServer, provider = query.JSONServer, o.resolveProvider(query.Provider)
 
-	fmt.Println("all json servers", GetJSONServers())
-	fmt.Println("target json server", jsonServer)
-	jsonServer, ok := GetJSONServers()[jsonServer]
+	jsonServers := GetJSONServers()
+	logger.Debug("resolve the JSON server", zap.Any("servers", jsonServers), zap.String("target", jsonServer))
+	defaultJSONServer := o.GetDefaultJSONServer()
+	jsonServer, ok := jsonServers[jsonServer]
 	if !ok {
-		jsonServer = o.DefaultJSONServer
+		jsonServer = defaultJSONServer
+	}
+
+	candidates := []string{jsonServer, defaultJSONServer}
+	for _, name := range sortedKeys(jsonServers) {
+		candidates = append(candidates, jsonServers[name])
 	}
+	jsonServer = o.HealthChecker.PickHealthy(ProbeKindJSONServer, candidates...)
 
 	targetURL = fmt.Sprintf("%s/%s%s", jsonServer, provider, official.RequestURI())
 	return
 }
 
+// resolveProvider returns the provider of the update center, it's the default one if the given one does not exist.
+// Another healthy provider will be returned if the health checker considers the expected one is down
+func (o *ServerOptions) resolveProvider(name string) string {
+	registry := GetProviderRegistry()
+	if name == "" || !registry.Has(name) {
+		name = o.DefaultProvider
+	}
+	return o.HealthChecker.PickHealthy(ProbeKindProvider, append([]string{name}, registry.Names()...)...)
+}
+
+// GetUpdateCenterURL returns the URL of the latest update center from the default provider
+func (o *ServerOptions) GetUpdateCenterURL() string {
+	official, _ := url.Parse(OfficialUpdateCenterURL)
+	return o.GetProviderURL(official, UpdateCenterQuery{})
+}
+
+// GetExternalURL returns the URL which the clients use to access this server.
+// It's not taken from the Host header, the clients could give any value there
+func (o *ServerOptions) GetExternalURL() string {
+	return strings.TrimSuffix(o.ExternalURL, "/")
+}
+
 // UpdateCenterQuery holds the info for query a update center
 type UpdateCenterQuery struct {
 	Version      string
@@ -141,6 +392,26 @@ type QuerySource interface {
 
 // Run startup a server
 func (o *ServerOptions) Run(cmd *cobra.Command, args []string) (err error) {
+	var serverLogger *zap.Logger
+	if serverLogger, err = NewLogger(o.LogLevel, o.LogFormat); err != nil {
+		return
+	}
+	SetLogger(serverLogger)
+	defer func() {
+		_ = serverLogger.Sync()
+	}()
+
+	for _, key := range UnknownConfigKeys(cmd.Flags()) {
+		logger.Warn("unknown key in the config", zap.String("key", key))
+	}
+
+	configLock.RLock()
+	err = validateDefaultProvider(viper.GetViper(), o.DefaultProvider)
+	configLock.RUnlock()
+	if err != nil {
+		return
+	}
+
 	mux := http.NewServeMux()
 
 	mux.Handle("/update-center.json", AddContext(http.HandlerFunc(HandleUpdateCenter), o))
@@ -149,31 +420,226 @@ func (o *ServerOptions) Run(cmd *cobra.Command, args []string) (err error) {
 	mux.Handle("/providers", AddContext(http.HandlerFunc(HandleProviders), o))
 	mux.Handle("/providers/default", AddContext(http.HandlerFunc(HandleDefaultProvider), o))
 	mux.Handle("/jenkins/plugins/", AddContext(http.HandlerFunc(HandlePluginDownload), o))
+	mux.Handle("/jenkins/war/", AddContext(http.HandlerFunc(HandlePluginDownload), o))
 	mux.Handle("/plugins", AddContext(http.HandlerFunc(HandlePluginsData), o))
 	mux.Handle("/plugins/list", AddContext(http.HandlerFunc(HandlePluginsDataList), o))
+	mux.Handle("/plugins/top", AddContext(http.HandlerFunc(HandlePluginsTop), o))
+	mux.Handle("/plugins/export", AddContext(http.HandlerFunc(HandlePluginsExport), o))
+	mux.Handle("/metrics", AddContext(http.HandlerFunc(HandleMetrics), o))
 	mux.Handle("/status", AddContext(http.HandlerFunc(HandleHealthCheck), o))
+	mux.Handle("/cache/artifacts", AddContext(http.HandlerFunc(HandleArtifactCacheStats), o))
+
+	o.Metrics = NewMetrics()
 
+	if o.HealthCheckInterval > 0 {
+		o.HealthChecker = NewHealthChecker(o.HealthCheckInterval, o.HealthCheckTimeout, func() []ProbeTarget {
+			options := o.snapshot()
+			return options.GetProbeTargets()
+		})
+		o.HealthChecker.Start()
+		defer o.HealthChecker.Stop()
+	}
+
+	var closeCounter func()
+	if o.Counter, closeCounter, err = o.openPluginDownloadCounter(false); err != nil {
+		return
+	}
+	// it's the last one to close, all the counts are saved into the counter before it
+	defer closeCounter()
+
+	if gitCounter, ok := o.Counter.(*GitPluginDownloadCounter); ok && o.GitRemote != "" {
+		gitCounter.Remote, gitCounter.Branch = o.GitRemote, o.GitBranch
+		gitCounter.Username, gitCounter.Password = o.GitUsername, o.GitPassword
+		if err = gitCounter.Sync(); err != nil {
+			return
+		}
+
+		gitCounter.StartSync(o.GitSyncInterval)
+		// it's deferred before flushing the aggregator, so the last counts can be pushed
+		defer func() {
+			gitCounter.StopSync()
+			if pushErr := gitCounter.Push(); pushErr != nil {
+				logger.Error("cannot push the download data", zap.Error(pushErr))
+			}
+		}()
+	}
+
+	if o.FlushInterval > 0 {
+		o.Aggregator = NewDownloadAggregator(o.Counter, o.FlushInterval)
+		o.Aggregator.Start()
+		defer func() {
+			if flushErr := o.Aggregator.Close(); flushErr != nil {
+				logger.Error("cannot flush the download counts", zap.Error(flushErr))
+			}
+		}()
+	}
+
+	if o.SignCertFile != "" && o.SignKeyFile != "" {
+		if o.Signer, err = NewUpdateCenterSigner(o.SignCertFile, o.SignKeyFile); err != nil {
+			return
+		}
+	}
+	if o.RewriteUpdateCenter {
+		if o.ExternalURL == "" {
+			return errExternalURLRequired
+		}
+		o.RewriteCache = NewRewrittenUpdateCenters(o.RewriteCacheTTL)
+	}
+
+	switch o.CacheType {
+	case CacheTypeRedis:
+		// the replicas share the cache in Redis, so it's not kept in memory
+		redisCache := o.newCacheServer().(*RedisCacheServer)
+		defer func() {
+			_ = redisCache.Close()
+		}()
+		o.Cache = redisCache
+	case CacheTypeFile, "":
+		var cacheBackend CacheServer
+		if o.CacheWriteThrough {
+			cacheBackend = o.newCacheServer()
+		}
+		var memoryCache *MemoryCacheServer
+		if memoryCache, err = NewMemoryCacheServer(o.CacheTTL, cacheBackend); err != nil {
+			logger.Warn("cannot load the cached update center URLs", zap.Error(err))
+			err = nil
+		}
+		// the resolved URLs are written into the cache file in the background, the pending ones are written before exiting
+		defer func() {
+			if flushErr := memoryCache.Flush(); flushErr != nil {
+				logger.Error("cannot write the cached update center URLs", zap.Error(flushErr))
+			}
+		}()
+		o.Cache = memoryCache
+	default:
+		return fmt.Errorf("unknown cache type: %s", o.CacheType)
+	}
+
+	if o.TiersSource != "" {
+		o.Tiers = NewTierResolver(o.TiersSource, o.TiersInterval)
+		// the upstream is used until the manifest is loaded, so the server does not wait for it
+		o.Tiers.Start()
+		defer o.Tiers.Stop()
+	}
+
+	if o.CacheRefreshInterval > 0 && o.CacheTTL > 0 {
+		refresher := NewCacheRefresher(o.GetCacheServer, o.GetURL, o.CacheRefreshInterval)
+		refresher.Start()
+		defer refresher.Stop()
+	}
+
+	// the plugin names of the metrics are checked against the update center
+	checksums := &UpdateCenterChecksums{
+		URL: func() string {
+			options := o.snapshot()
+			return options.GetUpdateCenterURL()
+		},
+		Refresh: time.Hour,
+	}
+	o.Metrics.KnownPlugin = checksums.HasPlugin
+
+	if o.ArtifactCacheDir != "" {
+		if o.ArtifactCache, err = NewArtifactCache(o.ArtifactCacheDir, o.ArtifactCacheMaxSize, checksums); err != nil {
+			return
+		}
+		o.ArtifactCache.Client = &http.Client{Timeout: o.ArtifactCacheDownloadTimeout}
+		o.ArtifactCache.CacheUnverified = o.ArtifactCacheUnverified
+	}
+
+	var handler http.Handler = mux
+	if o.AccessLogFile != "" {
+		switch o.AccessLogFormat {
+		case AccessLogFormatCombined, AccessLogFormatCommon, AccessLogFormatJSON:
+		default:
+			return fmt.Errorf("unknown access log format: %s", o.AccessLogFormat)
+		}
+
+		accessLog := &RotatingWriter{
+			Path:           o.AccessLogFile,
+			MaxSize:        o.AccessLogMaxSize,
+			RotateInterval: o.AccessLogRotateInterval,
+			MaxBackups:     o.AccessLogMaxBackups,
+			MaxAge:         o.AccessLogMaxAge,
+		}
+		defer func() {
+			_ = accessLog.Close()
+		}()
+		handler = AccessLog(mux, accessLog, o.AccessLogFormat)
+	}
+
+	var ltsServer *http.Server
 	if serverOptions.EnableLTS {
+		// the certificate could be replaced when the config is reloaded
+		o.Certificate = &CertificateStore{}
+		if err = o.Certificate.Load(o.CertFile, o.KeyFile); err != nil {
+			return
+		}
+
+		ltsServer = &http.Server{
+			Handler:   handler,
+			Addr:      fmt.Sprintf("%s:%d", o.Host, o.PortLTS),
+			TLSConfig: &tls.Config{GetCertificate: o.Certificate.GetCertificate},
+		}
 		go func() {
-			ltsServer := http.Server{
-				Handler: mux,
-				Addr:    fmt.Sprintf("%s:%d", o.Host, o.PortLTS),
+			if ltsErr := ltsServer.ListenAndServeTLS("", ""); ltsErr != http.ErrServerClosed {
+				logger.Error("cannot start the LTS server", zap.Error(ltsErr))
 			}
-			err = ltsServer.ListenAndServeTLS(o.CertFile, o.KeyFile)
 		}()
 	}
 
-	server := http.Server{
-		Handler: mux,
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	o.WatchConfig(cmd.Flags(), stopWatching)
+
+	server := &http.Server{
+		Handler: handler,
 		Addr:    fmt.Sprintf("%s:%d", o.Host, o.Port),
 	}
 
-	fmt.Printf("prepare to start server %s:%d\n", o.Host, o.Port)
+	// the pending tasks are done after the servers stop, and before the download counts are flushed
+	if o.WorkPool != nil {
+		defer o.WorkPool.ClosePool()
+	}
+
+	// stop the servers gracefully when it gets the stop signals, then the download counts can be flushed
+	stopped := make(chan struct{})
+	shutdown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		defer close(shutdown)
+		select {
+		case sig := <-signals:
+			logger.Info("prepare to stop server", zap.String("signal", sig.String()), zap.Duration("timeout", o.ShutdownTimeout))
+			_ = ShutdownServers(o.ShutdownTimeout, server, ltsServer)
+		case <-stopped:
+		}
+	}()
+
+	logger.Info("prepare to start server", zap.String("host", o.Host), zap.Int("port", o.Port))
 
 	err = server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		err = nil
+	} else {
+		close(stopped)
+		if ltsServer != nil {
+			_ = ltsServer.Close()
+		}
+	}
+	<-shutdown
 	return
 }
 
+// GetUpstream returns the URL of the official update center without the trailing slash
+func (o *ServerOptions) GetUpstream() string {
+	if o.Upstream == "" {
+		return DefaultUpstream
+	}
+	return strings.TrimSuffix(o.Upstream, "/")
+}
+
 // GetURL get the real URL from the official site
 func (o *ServerOptions) GetURL(version string) (targetURL *url.URL, err error) {
 	var (
@@ -181,10 +647,11 @@ func (o *ServerOptions) GetURL(version string) (targetURL *url.URL, err error) {
 		response *http.Response
 	)
 
-	api := fmt.Sprintf("https://updates.jenkins.io/update-center.json?version=%s", version)
+	api := fmt.Sprintf("%s/update-center.json?version=%s", o.GetUpstream(), url.QueryEscape(version))
 	request, err = http.NewRequest("GET", api, nil)
 	if err == nil {
 		client := &http.Client{
+			Timeout: DefaultFetchTimeout,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
 			},
@@ -192,37 +659,107 @@ func (o *ServerOptions) GetURL(version string) (targetURL *url.URL, err error) {
 
 		response, err = client.Do(request)
 		if err == nil {
+			_ = response.Body.Close()
 			targetURL, err = response.Location()
 		}
 	}
 	return
 }
 
-// GetAndCacheURL get the real URL, then cache it
-func (o *ServerOptions) GetAndCacheURL(query UpdateCenterQuery) (targetURL *url.URL, err error) {
-	var cacheErr error
+// GetCacheServer returns the cache of the update center URLs, it's the cache file if the server is not started
+func (o *ServerOptions) GetCacheServer() CacheServer {
+	if o.Cache != nil {
+		return o.Cache
+	}
+	return o.newCacheServer()
+}
+
+func (o *ServerOptions) newCacheServer() CacheServer {
+	if o.CacheType == CacheTypeRedis {
+		return &RedisCacheServer{
+			Address:   o.CacheRedisAddress,
+			Password:  o.CacheRedisPassword,
+			DB:        o.CacheRedisDB,
+			KeyPrefix: o.CacheRedisKeyPrefix,
+			PoolSize:  o.CacheRedisPoolSize,
+			TTL:       o.CacheTTL,
+		}
+	}
+
+	fileName := o.CacheFile
+	if fileName == "" {
+		fileName = "cache.yaml"
+	}
+	return &FileSystemCacheServer{FileName: fileName, TTL: o.CacheTTL}
+}
 
+// GetAndCacheURL get the real URL, then cache it. It's resolved by the tier manifest without the upstream if possible.
+// The concurrent lookups of the same version share one request to the upstream
+func (o *ServerOptions) GetAndCacheURL(query UpdateCenterQuery) (targetURL *url.URL, err error) {
 	if query.Experimental {
-		return url.Parse("https://updates.jenkins.io/experimental/update-center.json")
+		return url.Parse(o.GetUpstream() + "/experimental/update-center.json")
 	}
 
 	version := query.Version
-	cacheServer := FileSystemCacheServer{FileName: "cache.yaml"}
-	if cacheURL := cacheServer.Load(version); cacheURL != "" {
-		targetURL, cacheErr = url.Parse(cacheURL)
-	} else {
-		if targetURL, err = o.GetURL(version); err == nil {
-			if cacheErr = cacheServer.Save(version, targetURL.String()); cacheErr != nil {
-				log.Println(cacheErr)
-			}
+	// the version is a part of the cache key and the upstream request
+	if version != "" && !IsJenkinsVersion(version) {
+		err = fmt.Errorf("invalid Jenkins version: %q", version)
+		return
+	}
+	if o.Tiers != nil {
+		var ok bool
+		if targetURL, ok = o.Tiers.Resolve(o.GetUpstream(), version); ok {
+			return
 		}
 	}
 
-	if cacheErr != nil {
-		if targetURL, err = o.GetURL(version); err == nil {
-			if cacheErr = cacheServer.Save(version, targetURL.String()); cacheErr != nil {
-				log.Println(cacheErr)
-			}
+	cacheServer := o.GetCacheServer()
+	item, cached := cacheServer.LoadItem(version)
+	if cached && !item.Expired() {
+		if item.Negative {
+			err = fmt.Errorf("cannot get the update center URL of version %s: %s", version, item.Value)
+			return
+		}
+		if targetURL, err = url.Parse(item.Value); err == nil {
+			return
+		}
+	}
+
+	var value interface{}
+	key := o.GetUpstream() + "?version=" + version
+	if value, err, _ = updateCenterLookups.Do(key, func() (interface{}, error) {
+		return o.fetchAndCacheURL(cacheServer, version, item, cached)
+	}); err == nil {
+		// the waiters share the same URL
+		sharedURL := *value.(*url.URL)
+		targetURL = &sharedURL
+	}
+	return
+}
+
+// updateCenterLookups coalesces the lookups of the update center URLs
+var updateCenterLookups lookupGroup
+
+// fetchAndCacheURL resolves the URL from the upstream, then caches it.
+// The expired URL is used if it cannot be resolved and is not removed by the flush of the cache, the failed lookup is cached if the negative TTL is given
+func (o *ServerOptions) fetchAndCacheURL(cacheServer CacheServer, version string, item CacheItem, cached bool) (
+	targetURL *url.URL, err error) {
+	if targetURL, err = o.GetURL(version); err == nil {
+		if cacheErr := cacheServer.Save(version, targetURL.String()); cacheErr != nil {
+			logger.Warn("cannot cache the update center URL", zap.Error(cacheErr))
+		}
+		return
+	}
+
+	if cached && !item.Negative {
+		logger.Warn("use the expired update center URL", zap.String("version", version), zap.Error(err))
+		return url.Parse(item.Value)
+	}
+
+	if o.CacheNegativeTTL > 0 {
+		negative := CacheItem{Value: err.Error(), FetchTime: time.Now(), TTL: o.CacheNegativeTTL, Negative: true}
+		if cacheErr := cacheServer.SaveItem(version, negative); cacheErr != nil {
+			logger.Warn("cannot cache the failed lookup", zap.Error(cacheErr))
 		}
 	}
 	return
@@ -4,7 +4,12 @@ import (
 	server "github.com/jenkins-zh/mirror-proxy/pkg"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 //var _ = Describe("server cmd test", func() {
@@ -58,4 +63,115 @@ var _ = Describe("GetAndCacheURL", func() {
 
 	Context("can not get from cache file", func() {
 	})
+
+	Context("get from the upstream", func() {
+		var (
+			upstream *httptest.Server
+			hits     int32
+			release  chan struct{}
+			status   int
+		)
+
+		BeforeEach(func() {
+			hits, status = 0, http.StatusFound
+			release = make(chan struct{})
+			upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				if r.URL.Query().Get("version") == "2.300" {
+					<-release
+				}
+				if status == http.StatusFound {
+					w.Header().Set("Location", "https://updates.jenkins.io/stable-2.263/update-center.json?version="+
+						r.URL.Query().Get("version"))
+				}
+				w.WriteHeader(status)
+			}))
+
+			cacheServer, cacheErr := server.NewMemoryCacheServer(time.Hour, nil)
+			Expect(cacheErr).NotTo(HaveOccurred())
+			opt.Upstream, opt.Cache = upstream.URL, cacheServer
+			query = server.UpdateCenterQuery{Version: "2.263.1"}
+		})
+
+		AfterEach(func() {
+			close(release)
+			upstream.Close()
+		})
+
+		It("get and cache the URL", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedURL.String()).To(Equal("https://updates.jenkins.io/stable-2.263/update-center.json?version=2.263.1"))
+
+			cachedURL, err = opt.GetAndCacheURL(query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedURL.String()).To(Equal("https://updates.jenkins.io/stable-2.263/update-center.json?version=2.263.1"))
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+		})
+
+		It("reject the invalid version", func() {
+			_, err = opt.GetAndCacheURL(server.UpdateCenterQuery{Version: "2.263.1<script>"})
+			Expect(err).To(MatchError(ContainSubstring("invalid Jenkins version")))
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+			Expect(opt.Cache.List()).To(HaveLen(1))
+		})
+
+		It("share the in-flight lookup", func() {
+			query.Version = "2.300"
+
+			var wait sync.WaitGroup
+			results := make([]string, 10)
+			for i := range results {
+				wait.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wait.Done()
+					targetURL, lookupErr := opt.GetAndCacheURL(query)
+					Expect(lookupErr).NotTo(HaveOccurred())
+					results[i] = targetURL.String()
+				}(i)
+			}
+
+			// the first lookup of 2.263.1 is done before
+			Eventually(func() int32 {
+				return atomic.LoadInt32(&hits)
+			}).Should(Equal(int32(2)))
+			// let the others wait for the in-flight lookup
+			time.Sleep(100 * time.Millisecond)
+			release <- struct{}{}
+			wait.Wait()
+
+			Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+			for _, result := range results {
+				Expect(result).To(Equal("https://updates.jenkins.io/stable-2.263/update-center.json?version=2.300"))
+			}
+		})
+
+		Context("the upstream fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+				query.Version = "2.301"
+			})
+
+			It("cache the failed lookup", func() {
+				Expect(err).To(HaveOccurred())
+
+				opt.CacheNegativeTTL = time.Minute
+				_, err = opt.GetAndCacheURL(query)
+				Expect(err).To(HaveOccurred())
+				_, err = opt.GetAndCacheURL(query)
+				Expect(err).To(MatchError(ContainSubstring("cannot get the update center URL of version 2.301")))
+				Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+			})
+
+			It("use the expired URL", func() {
+				Expect(opt.Cache.SaveItem("2.301", server.CacheItem{
+					Value: "https://a.com/update-center.json", FetchTime: time.Now().Add(-2 * time.Hour), TTL: time.Hour,
+				})).To(Succeed())
+
+				cachedURL, err = opt.GetAndCacheURL(query)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cachedURL.String()).To(Equal("https://a.com/update-center.json"))
+			})
+		})
+	})
 })
@@ -1,14 +1,145 @@
 package pkg
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnvPrefix is the prefix of the env variables, for example: MIRROR_PROXY_DEFAULT_PROVIDER
+const EnvPrefix = "MIRROR_PROXY"
+
+// configAliases are the camel case keys which are accepted in the config file, the values are the flag names
+var configAliases = map[string]string{
+	"defaultProvider":   "default-provider",
+	"defaultJSONServer": "default-json-server",
+}
+
+// configKeys are the keys of the config file which are not flags
+var configKeys = []string{"providers", "providerMirrors", "jsonServers"}
+
+// configLock guards the config which could be reloaded at runtime,
+// including the reloadable fields of the server options
+var configLock sync.RWMutex
 
 // GetProviders get all providers
 func GetProviders() (providers []string) {
-	providers = viper.GetStringSlice("providers")
-	return
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return getProviders(viper.GetViper())
+}
+
+func getProviders(config *viper.Viper) []string {
+	return config.GetStringSlice("providers")
 }
 
 // GetJSONServers get all JSON servers
 func GetJSONServers() map[string]string {
-	return viper.GetStringMapString("jsonServers")
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return getJSONServers(viper.GetViper())
+}
+
+func getJSONServers(config *viper.Viper) map[string]string {
+	return config.GetStringMapString("jsonServers")
+}
+
+// GetDefaultJSONServer returns the URL of the default JSON server, it could be the name of a JSON server
+func (o *ServerOptions) GetDefaultJSONServer() string {
+	for name, jsonServer := range GetJSONServers() {
+		if strings.EqualFold(name, o.DefaultJSONServer) {
+			return jsonServer
+		}
+	}
+	return o.DefaultJSONServer
+}
+
+// bindFlags lets the flags can be read from the env variables and the config file
+func bindFlags(flags *pflag.FlagSet) {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name != "config" {
+			_ = viper.BindPFlag(flag.Name, flag)
+		}
+	})
+}
+
+// applyConfig sets the flags which are not given from the env variables or the config file.
+// The precedence is: flag > env > config file > default
+func applyConfig(flags *pflag.FlagSet) (err error) {
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "config" {
+			return
+		}
+
+		for _, key := range flagConfigKeys(flag.Name) {
+			if viper.IsSet(key) {
+				if setErr := flag.Value.Set(viper.GetString(key)); setErr != nil {
+					err = fmt.Errorf("invalid value of %s: %v", key, setErr)
+				}
+				return
+			}
+		}
+	})
+	return
+}
+
+// flagConfigKeys returns the keys of a flag in the config file, the flag name comes first, then the aliases
+func flagConfigKeys(name string) (keys []string) {
+	keys = []string{name}
+	for alias, flagName := range configAliases {
+		if flagName == name {
+			keys = append(keys, alias)
+		}
+	}
+	return
+}
+
+// UnknownConfigKeys returns the keys of the config file which are neither the flags nor the known config keys
+func UnknownConfigKeys(flags *pflag.FlagSet) (keys []string) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return unknownConfigKeys(viper.GetViper(), flags)
+}
+
+func unknownConfigKeys(config *viper.Viper, flags *pflag.FlagSet) (keys []string) {
+	known := map[string]bool{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		known[flag.Name] = true
+	})
+	for _, key := range configKeys {
+		known[strings.ToLower(key)] = true
+	}
+	for alias := range configAliases {
+		known[strings.ToLower(alias)] = true
+	}
+
+	unknown := map[string]bool{}
+	for _, key := range config.AllKeys() {
+		if key = strings.Split(key, ".")[0]; !known[key] {
+			unknown[key] = true
+		}
+	}
+
+	keys = make([]string, 0, len(unknown))
+	for key := range unknown {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func sortedKeys(data map[string]string) (keys []string) {
+	keys = make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
 }
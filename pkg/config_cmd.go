@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Check the config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the config file and the flags",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		problems := serverOptions.Validate()
+		for _, problem := range problems {
+			cmd.Println(problem)
+		}
+
+		if len(problems) > 0 {
+			err = fmt.Errorf("found %d problems in the config", len(problems))
+		} else {
+			cmd.Println("the config is valid")
+		}
+		return
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Print the config which is loaded from the config file",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		var data []byte
+		if data, err = yaml.Marshal(maskSecrets(viper.AllSettings())); err == nil {
+			cmd.Print(string(data))
+		}
+		return
+	},
+}
+
+// secretConfigKeys are the keys whose values should not be printed
+var secretConfigKeys = []string{"git-password", "cache-redis-password"}
+
+func maskSecrets(settings map[string]interface{}) map[string]interface{} {
+	for _, key := range secretConfigKeys {
+		if value, ok := settings[key]; ok && fmt.Sprint(value) != "" {
+			settings[key] = "******"
+		}
+	}
+	return settings
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd, configPrintCmd)
+}
+
+var errExternalURLRequired = fmt.Errorf("the external-url is required to rewrite the update center")
+
+// Validate returns the problems of the options and the config file
+func (o *ServerOptions) Validate() (problems []error) {
+	configLock.RLock()
+	problems = validateConfig(viper.GetViper(), rootCmd.PersistentFlags())
+	if err := validateDefaultProvider(viper.GetViper(), o.DefaultProvider); err != nil {
+		problems = append(problems, err)
+	}
+	configLock.RUnlock()
+
+	if _, err := NewLogger(o.LogLevel, o.LogFormat); err != nil {
+		problems = append(problems, err)
+	}
+
+	switch o.CounterType {
+	case CounterTypeGit, CounterTypeBolt:
+	default:
+		problems = append(problems, fmt.Errorf("unknown counter type: %s", o.CounterType))
+	}
+
+	switch o.CacheType {
+	case CacheTypeFile, CacheTypeRedis:
+	default:
+		problems = append(problems, fmt.Errorf("unknown cache type: %s", o.CacheType))
+	}
+
+	switch o.AccessLogFormat {
+	case AccessLogFormatCombined, AccessLogFormatCommon, AccessLogFormatJSON:
+	default:
+		problems = append(problems, fmt.Errorf("unknown access log format: %s", o.AccessLogFormat))
+	}
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		problems = append(problems, fmt.Errorf("the cert and key files should be given together"))
+	}
+	if (o.SignCertFile == "") != (o.SignKeyFile == "") {
+		problems = append(problems, fmt.Errorf("the sign-cert and sign-key files should be given together"))
+	}
+	if o.RewriteUpdateCenter && o.ExternalURL == "" {
+		problems = append(problems, errExternalURLRequired)
+	}
+	return
+}
+
+// validateConfig returns the problems of the providers, the JSON servers and the keys in the config
+func validateConfig(config *viper.Viper, flags *pflag.FlagSet) (problems []error) {
+	mirrors := getProviderMirrors(config)
+	for _, name := range ProviderRegistry(mirrors).Names() {
+		if provider := mirrors[name]; !isAbsoluteURL(provider.URL) {
+			problems = append(problems, fmt.Errorf("the URL of provider %s is invalid: %q", name, provider.URL))
+		}
+	}
+
+	jsonServers := getJSONServers(config)
+	for _, name := range sortedKeys(jsonServers) {
+		if !isAbsoluteURL(jsonServers[name]) {
+			problems = append(problems, fmt.Errorf("the URL of JSON server %s is invalid: %q", name, jsonServers[name]))
+		}
+	}
+
+	for _, key := range unknownConfigKeys(config, flags) {
+		problems = append(problems, fmt.Errorf("unknown key in the config: %s", key))
+	}
+	return
+}
+
+// validateDefaultProvider checks if the default provider is one of the providers in the config
+func validateDefaultProvider(config *viper.Viper, name string) (err error) {
+	// the builtin provider is used when there are no providers
+	if registry := getProviderRegistry(config); len(registry) > 0 && !registry.Has(name) {
+		err = fmt.Errorf("the default provider %s is not in the providers", name)
+	}
+	return
+}
+
+func isAbsoluteURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
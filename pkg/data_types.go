@@ -1,5 +1,10 @@
 package pkg
 
+import (
+	"fmt"
+	"time"
+)
+
 // PluginDownloadData represents the plugins download data
 type PluginDownloadData struct {
 	Year    string
@@ -9,4 +14,91 @@ type PluginDownloadData struct {
 // PluginData represents a plugin data
 type PluginData struct {
 	Data map[string]int64
+	// Providers holds the counts of each provider, the key is the provider name
+	Providers map[string]map[string]int64 `yaml:",omitempty" json:",omitempty"`
+	// Versions holds the counts of each Jenkins version, the key is the Jenkins version
+	Versions map[string]map[string]int64 `yaml:",omitempty" json:",omitempty"`
+}
+
+// ProviderBreakdown is the provider filter which returns the counts of all providers
+const ProviderBreakdown = "*"
+
+// FilterByProvider returns the counts of the given provider.
+// It returns the total counts without any dimensions if the provider is empty,
+// or all the dimensions if the provider is ProviderBreakdown
+func (p PluginData) FilterByProvider(provider string) (data PluginData) {
+	switch provider {
+	case "":
+		data.Data = p.Data
+	case ProviderBreakdown:
+		data = p
+	default:
+		data.Data = p.Providers[provider]
+		if data.Data == nil {
+			data.Data = map[string]int64{}
+		}
+	}
+	return
+}
+
+// AddCount adds the count of a plugin
+func (d *PluginDownloadData) AddCount(count DownloadCount) {
+	if d.Plugins == nil {
+		d.Plugins = map[string]PluginData{}
+	}
+
+	pluginData := d.Plugins[count.Plugin]
+	if pluginData.Data == nil {
+		pluginData.Data = map[string]int64{}
+	}
+	pluginData.Data[count.Date] += count.Count
+
+	if count.Provider != "" {
+		pluginData.Providers = addDimensionCount(pluginData.Providers, count.Provider, count.Date, count.Count)
+	}
+	if count.Version != "" {
+		pluginData.Versions = addDimensionCount(pluginData.Versions, count.Version, count.Date, count.Count)
+	}
+	d.Plugins[count.Plugin] = pluginData
+}
+
+func addDimensionCount(dimension map[string]map[string]int64, key, date string, count int64) map[string]map[string]int64 {
+	if dimension == nil {
+		dimension = map[string]map[string]int64{}
+	}
+	if dimension[key] == nil {
+		dimension[key] = map[string]int64{}
+	}
+	dimension[key][date] += count
+	return dimension
+}
+
+// DownloadCount represents the count of a plugin in a day
+type DownloadCount struct {
+	Plugin   string
+	Provider string
+	// Version is the Jenkins version, it might be empty
+	Version string
+	// Date is formatted as 2006-01-02
+	Date  string
+	Count int64
+}
+
+// Validate checks if the count could be stored, such as the plugin name is used as a key
+func (c DownloadCount) Validate() (err error) {
+	if !IsPluginName(c.Plugin) {
+		return fmt.Errorf("invalid plugin name: %q", c.Plugin)
+	}
+	if _, err = time.Parse(dateLayout, c.Date); err != nil {
+		err = fmt.Errorf("invalid date of the plugin %s: %q", c.Plugin, c.Date)
+	}
+	return
+}
+
+// Year returns the year of the date
+func (c DownloadCount) Year() string {
+	if len(c.Date) < 4 {
+		return c.Date
+	}
+	return c.Date[:4]
 }
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DownloadAggregator aggregates the download counts in memory, then flushes them into the counter periodically
+type DownloadAggregator struct {
+	Counter  PluginDownloadCounter
+	Interval time.Duration
+
+	lock   sync.Mutex
+	counts map[DownloadCount]int64
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewDownloadAggregator creates an aggregator
+func NewDownloadAggregator(counter PluginDownloadCounter, interval time.Duration) *DownloadAggregator {
+	return &DownloadAggregator{
+		Counter:  counter,
+		Interval: interval,
+		counts:   map[DownloadCount]int64{},
+	}
+}
+
+// Start flushes the counts periodically until Close is called
+func (a *DownloadAggregator) Start() {
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.Flush(); err != nil {
+					logger.Error("cannot flush the download counts", zap.Error(err))
+				}
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// RecordPluginDownloadData counts a plugin download, the Jenkins version might be empty
+func (a *DownloadAggregator) RecordPluginDownloadData(plugin, provider, version string) {
+	a.Add(DownloadCount{Plugin: plugin, Provider: provider, Version: version, Date: GetDate()})
+}
+
+// RecordUpdateCenterVisitData counts an update center visit, the Jenkins version might be empty
+func (a *DownloadAggregator) RecordUpdateCenterVisitData(provider, version string) {
+	a.Add(DownloadCount{Plugin: "update-center", Provider: provider, Version: version, Date: GetDate()})
+}
+
+// Add counts once, the Count field of the given key will be ignored
+func (a *DownloadAggregator) Add(key DownloadCount) {
+	key.Count = 0
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.counts[key]++
+}
+
+// Pending returns the number of the counts which are not flushed
+func (a *DownloadAggregator) Pending() (count int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, val := range a.counts {
+		count += val
+	}
+	return
+}
+
+// Flush saves all the aggregated counts into the counter, they will be kept in memory if the saving failed.
+// The invalid counts are dropped, they cannot be stored and would fail all the later flushes
+func (a *DownloadAggregator) Flush() (err error) {
+	a.lock.Lock()
+	pending := a.counts
+	a.counts = map[DownloadCount]int64{}
+	a.lock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	counts := make([]DownloadCount, 0, len(pending))
+	for key, count := range pending {
+		if invalidErr := key.Validate(); invalidErr != nil {
+			logger.Warn("drop the invalid download count", zap.Int64("count", count), zap.Error(invalidErr))
+			delete(pending, key)
+			continue
+		}
+		key.Count = count
+		counts = append(counts, key)
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	if err = a.Counter.SaveCounts(counts); err != nil {
+		a.lock.Lock()
+		for key, count := range pending {
+			a.counts[key] += count
+		}
+		a.lock.Unlock()
+	}
+	return
+}
+
+// Close stops the periodic flushing, then flushes the remaining counts
+func (a *DownloadAggregator) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		<-a.done
+		a.stop = nil
+	}
+	return a.Flush()
+}
@@ -0,0 +1,109 @@
+package pkg_test
+
+import (
+	"fmt"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FakeCounter only for test
+type FakeCounter struct {
+	server.GitPluginDownloadCounter
+
+	Batches [][]server.DownloadCount
+	Err     error
+}
+
+// SaveCounts only for test
+func (f *FakeCounter) SaveCounts(counts []server.DownloadCount) error {
+	if f.Err == nil {
+		f.Batches = append(f.Batches, counts)
+	}
+	return f.Err
+}
+
+var _ = Describe("DownloadAggregator", func() {
+	var (
+		counter    *FakeCounter
+		aggregator *server.DownloadAggregator
+	)
+
+	BeforeEach(func() {
+		counter = &FakeCounter{}
+		aggregator = server.NewDownloadAggregator(counter, time.Hour)
+	})
+
+	It("should aggregate the counts", func() {
+		for i := 0; i < 100; i++ {
+			aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		}
+		aggregator.RecordPluginDownloadData("ant", "tsinghua", "")
+		aggregator.RecordUpdateCenterVisitData("", "2.263.1")
+		Expect(aggregator.Pending()).To(Equal(int64(102)))
+
+		Expect(aggregator.Flush()).To(Succeed())
+		Expect(aggregator.Pending()).To(BeZero())
+		Expect(len(counter.Batches)).To(Equal(1))
+		Expect(counter.Batches[0]).To(ContainElement(server.DownloadCount{
+			Plugin: "git", Provider: "tsinghua", Date: server.GetDate(), Count: 100,
+		}))
+		Expect(len(counter.Batches[0])).To(Equal(3))
+
+		Expect(aggregator.Flush()).To(Succeed())
+		Expect(len(counter.Batches)).To(Equal(1))
+	})
+
+	It("should keep the counts if the saving failed", func() {
+		counter.Err = fmt.Errorf("fake")
+		aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		Expect(aggregator.Flush()).NotTo(Succeed())
+		Expect(aggregator.Pending()).To(Equal(int64(1)))
+	})
+
+	It("should drop the counts which cannot be stored", func() {
+		dir, err := ioutil.TempDir("", "aggregator")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			_ = os.RemoveAll(dir)
+		}()
+		boltCounter, err := server.NewBoltPluginDownloadCounter(filepath.Join(dir, "plugins.db"))
+		Expect(err).NotTo(HaveOccurred())
+		defer func() {
+			_ = boltCounter.Close()
+		}()
+		aggregator.Counter = boltCounter
+
+		aggregator.RecordPluginDownloadData("", "tsinghua", "")
+		aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		Expect(aggregator.Flush()).To(Succeed())
+		Expect(aggregator.Pending()).To(BeZero())
+
+		aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		Expect(aggregator.Flush()).To(Succeed())
+		data, err := boltCounter.FindByYear(server.GetCurrentYear())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Plugins).To(HaveLen(1))
+		Expect(data.Plugins["git"].Data[server.GetDate()]).To(Equal(int64(2)))
+	})
+
+	It("should flush when closing", func() {
+		aggregator.Start()
+		aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		Expect(aggregator.Close()).To(Succeed())
+		Expect(len(counter.Batches)).To(Equal(1))
+	})
+
+	It("should flush periodically", func() {
+		aggregator.Interval = 10 * time.Millisecond
+		aggregator.Start()
+		defer aggregator.Close()
+
+		aggregator.RecordPluginDownloadData("git", "tsinghua", "")
+		Eventually(aggregator.Pending).Should(BeZero())
+	})
+})
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// ExportFormatCSV writes a header line, then a line per plugin and date
+	ExportFormatCSV = "csv"
+	// ExportFormatJSON writes an array of the counts
+	ExportFormatJSON = "json"
+	// ExportFormatNDJSON writes a JSON object per line
+	ExportFormatNDJSON = "ndjson"
+)
+
+// ExportContentTypes are the content types of the export formats
+var ExportContentTypes = map[string]string{
+	ExportFormatCSV:    "text/csv",
+	ExportFormatJSON:   "application/json",
+	ExportFormatNDJSON: "application/x-ndjson",
+}
+
+// ExportRow is the download count of a plugin in a day
+type ExportRow struct {
+	Plugin string
+	Date   string
+	Count  int64
+}
+
+// ExportDownloadCounts writes the counts of all plugins in the range, the data is read and written year by year
+func ExportDownloadCounts(w io.Writer, counter PluginDownloadCounter, query StatisticsQuery, format string) (err error) {
+	var writeRow func(row ExportRow) error
+	var finish func() error
+
+	switch format {
+	case ExportFormatCSV:
+		csvWriter := csv.NewWriter(w)
+		if err = csvWriter.Write([]string{"plugin", "date", "count"}); err != nil {
+			return
+		}
+		writeRow = func(row ExportRow) error {
+			return csvWriter.Write([]string{row.Plugin, row.Date, strconv.FormatInt(row.Count, 10)})
+		}
+		finish = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	case ExportFormatJSON:
+		if _, err = io.WriteString(w, "["); err != nil {
+			return
+		}
+		rows := 0
+		writeRow = func(row ExportRow) (err error) {
+			var data []byte
+			if data, err = json.Marshal(row); err == nil {
+				if rows > 0 {
+					data = append([]byte(","), data...)
+				}
+				rows++
+				_, err = w.Write(data)
+			}
+			return
+		}
+		finish = func() (err error) {
+			_, err = io.WriteString(w, "]\n")
+			return
+		}
+	case ExportFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		writeRow = func(row ExportRow) error {
+			return encoder.Encode(row)
+		}
+		finish = func() error {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+
+	for year := query.From.Year(); year <= query.To.Year(); year++ {
+		downloadData, findErr := counter.FindByYear(strconv.Itoa(year))
+		if findErr != nil {
+			continue
+		}
+
+		for _, plugin := range sortedPluginNames(downloadData.Plugins) {
+			counts := downloadData.Plugins[plugin].FilterByProvider(query.Provider).Data
+			for _, date := range sortedCountKeys(counts) {
+				day, parseErr := time.Parse(dateLayout, date)
+				if parseErr != nil || day.Before(query.From) || day.After(query.To) {
+					continue
+				}
+
+				if err = writeRow(ExportRow{Plugin: plugin, Date: date, Count: counts[date]}); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return finish()
+}
+
+func sortedPluginNames(plugins map[string]PluginData) (names []string) {
+	names = make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,76 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+var _ = Describe("ExportDownloadCounts", func() {
+	var (
+		dir     string
+		counter *server.GitPluginDownloadCounter
+		query   server.StatisticsQuery
+		buf     *bytes.Buffer
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "export")
+		Expect(err).NotTo(HaveOccurred())
+
+		counter = &server.GitPluginDownloadCounter{Path: dir}
+		Expect(counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Date: "2019-12-31", Count: 1},
+			{Plugin: "git", Date: "2020-01-01", Count: 2},
+			{Plugin: "ant", Date: "2020-01-02", Count: 3},
+			{Plugin: "ant", Date: "2020-02-01", Count: 4},
+		})).To(Succeed())
+
+		query, err = server.ParseStatisticsQuery("", "", "2019-12-31", "2020-01-31", "")
+		Expect(err).NotTo(HaveOccurred())
+		buf = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("csv", func() {
+		Expect(server.ExportDownloadCounts(buf, counter, query, server.ExportFormatCSV)).To(Succeed())
+		Expect(buf.String()).To(Equal(`plugin,date,count
+git,2019-12-31,1
+ant,2020-01-02,3
+git,2020-01-01,2
+`))
+	})
+
+	It("json", func() {
+		Expect(server.ExportDownloadCounts(buf, counter, query, server.ExportFormatJSON)).To(Succeed())
+		Expect(buf.String()).To(Equal(`[{"Plugin":"git","Date":"2019-12-31","Count":1},` +
+			`{"Plugin":"ant","Date":"2020-01-02","Count":3},{"Plugin":"git","Date":"2020-01-01","Count":2}]
+`))
+	})
+
+	It("ndjson", func() {
+		Expect(server.ExportDownloadCounts(buf, counter, query, server.ExportFormatNDJSON)).To(Succeed())
+		Expect(buf.String()).To(Equal(`{"Plugin":"git","Date":"2019-12-31","Count":1}
+{"Plugin":"ant","Date":"2020-01-02","Count":3}
+{"Plugin":"git","Date":"2020-01-01","Count":2}
+`))
+	})
+
+	It("json without data", func() {
+		query, err = server.ParseStatisticsQuery("", "", "2010-01-01", "2010-01-31", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.ExportDownloadCounts(buf, counter, query, server.ExportFormatJSON)).To(Succeed())
+		Expect(buf.String()).To(Equal("[]\n"))
+	})
+
+	It("unknown format", func() {
+		Expect(server.ExportDownloadCounts(buf, counter, query, "xml")).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,267 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+const gitRemoteName = "origin"
+
+// gitCredentialHelper answers the credential of a HTTP repository from the env variables of the git command,
+// so the credential is neither stored in the remote URL nor visible in the arguments
+const gitCredentialHelper = `!f() { echo "username=${MIRROR_PROXY_SYNC_USERNAME}"; echo "password=${MIRROR_PROXY_SYNC_PASSWORD}"; }; f`
+
+// StartSync commits and pushes the data to the remote repository periodically until StopSync is called
+func (g *GitPluginDownloadCounter) StartSync(interval time.Duration) {
+	g.stopSync = make(chan struct{})
+	g.syncing.Add(1)
+	go func() {
+		defer g.syncing.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := g.Push(); err != nil {
+					logger.Error("cannot push the download data", zap.Error(err))
+				}
+			case <-g.stopSync:
+				return
+			}
+		}
+	}()
+}
+
+// StopSync stops the periodic pushing, and waits for the pushing in progress
+func (g *GitPluginDownloadCounter) StopSync() {
+	if g.stopSync != nil {
+		close(g.stopSync)
+		g.syncing.Wait()
+		g.stopSync = nil
+	}
+}
+
+// Push commits the data, merges the remote changes, then pushes to the remote repository
+func (g *GitPluginDownloadCounter) Push() (err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err = g.sync(); err != nil {
+		return
+	}
+
+	// nothing to push if there is no data
+	if _, headErr := g.git("rev-parse", "--verify", "--quiet", "HEAD"); headErr == nil {
+		_, err = g.git("push", gitRemoteName, "HEAD:"+g.getBranch())
+	}
+	return
+}
+
+// Sync commits the data, then pulls the remote changes and merges the counts
+func (g *GitPluginDownloadCounter) Sync() (err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.sync()
+}
+
+func (g *GitPluginDownloadCounter) sync() (err error) {
+	if g.Remote == "" {
+		err = fmt.Errorf("the remote git repository is not set")
+		return
+	}
+
+	if err = g.initRepo(); err != nil {
+		return
+	}
+	if err = g.commit("Update the download data"); err != nil {
+		return
+	}
+
+	if _, err = g.git("fetch", gitRemoteName, g.getBranch()); err != nil {
+		// the remote repository is empty
+		if strings.Contains(err.Error(), "couldn't find remote ref") {
+			err = nil
+		}
+		return
+	}
+
+	var head, base string
+	if head, err = g.git("rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// there is no local commit
+		_, err = g.git("reset", "--hard", "FETCH_HEAD")
+		return
+	}
+	if base, err = g.git("merge-base", "HEAD", "FETCH_HEAD"); err != nil {
+		return
+	}
+
+	var remote string
+	if remote, err = g.git("rev-parse", "FETCH_HEAD"); err != nil {
+		return
+	}
+
+	switch base {
+	case remote:
+		// nothing new in the remote
+	case head:
+		_, err = g.git("merge", "--ff-only", "FETCH_HEAD")
+	default:
+		err = g.mergeCounts(base)
+	}
+	return
+}
+
+// mergeCounts merges the counts from the common ancestor: local + remote - base
+func (g *GitPluginDownloadCounter) mergeCounts(base string) (err error) {
+	var files string
+	if files, err = g.git("ls-tree", "--name-only", "FETCH_HEAD"); err != nil {
+		return
+	}
+
+	remoteData := map[string]*PluginDownloadData{}
+	for _, file := range strings.Fields(files) {
+		year := strings.TrimSuffix(file, ".yaml")
+		if !strings.HasSuffix(file, ".yaml") || !isYear(year) {
+			continue
+		}
+
+		if remoteData[year], err = g.readRevision("FETCH_HEAD", file); err != nil {
+			return
+		}
+	}
+
+	// record the remote commit as a parent, the files will be replaced by the merged data
+	if _, err = g.git("merge", "--no-commit", "--no-ff", "-s", "ours", "FETCH_HEAD"); err != nil {
+		return
+	}
+
+	for year, remote := range remoteData {
+		var local, baseData *PluginDownloadData
+		if local, err = g.FindByYear(year); os.IsNotExist(err) {
+			local, err = &PluginDownloadData{Year: year}, nil
+		} else if err != nil {
+			return
+		}
+		if baseData, err = g.readRevision(base, year+".yaml"); err != nil {
+			return
+		}
+
+		mergeDownloadData(local, remote, baseData)
+		if err = g.Save(local); err != nil {
+			return
+		}
+	}
+	return g.commit("Merge the download data")
+}
+
+func (g *GitPluginDownloadCounter) readRevision(revision, file string) (downloadData *PluginDownloadData, err error) {
+	downloadData = &PluginDownloadData{}
+
+	var data string
+	if data, err = g.git("show", revision+":"+file); err != nil {
+		// the file does not exist in this revision
+		err = nil
+		return
+	}
+	err = yaml.Unmarshal([]byte(data), downloadData)
+	return
+}
+
+// mergeDownloadData adds the changes between base and remote into the local data
+func mergeDownloadData(local, remote, base *PluginDownloadData) {
+	for name, remotePlugin := range remote.Plugins {
+		basePlugin := base.Plugins[name]
+		for date, count := range remotePlugin.Data {
+			local.AddCount(DownloadCount{Plugin: name, Date: date, Count: count - basePlugin.Data[date]})
+		}
+
+		pluginData := local.Plugins[name]
+		for provider, counts := range remotePlugin.Providers {
+			for date, count := range counts {
+				delta := count - basePlugin.Providers[provider][date]
+				pluginData.Providers = addDimensionCount(pluginData.Providers, provider, date, delta)
+			}
+		}
+		for version, counts := range remotePlugin.Versions {
+			for date, count := range counts {
+				delta := count - basePlugin.Versions[version][date]
+				pluginData.Versions = addDimensionCount(pluginData.Versions, version, date, delta)
+			}
+		}
+		local.Plugins[name] = pluginData
+	}
+}
+
+func (g *GitPluginDownloadCounter) initRepo() (err error) {
+	if err = os.MkdirAll(g.Path, 0751); err != nil {
+		return
+	}
+
+	if _, statErr := os.Stat(path.Join(g.Path, ".git")); os.IsNotExist(statErr) {
+		if _, err = g.git("init"); err != nil {
+			return
+		}
+		if _, err = g.git("checkout", "-b", g.getBranch()); err != nil {
+			return
+		}
+	}
+
+	// the credential is given by the helper for each command, it's not a part of the remote URL
+	if _, getErr := g.git("remote", "get-url", gitRemoteName); getErr != nil {
+		_, err = g.git("remote", "add", gitRemoteName, g.Remote)
+	} else {
+		_, err = g.git("remote", "set-url", gitRemoteName, g.Remote)
+	}
+	return
+}
+
+func (g *GitPluginDownloadCounter) commit(message string) (err error) {
+	if _, err = g.git("add", "-A"); err != nil {
+		return
+	}
+
+	var status string
+	if status, err = g.git("status", "--porcelain"); err == nil && status != "" {
+		_, err = g.git("commit", "-m", message)
+	}
+	return
+}
+
+func (g *GitPluginDownloadCounter) getBranch() string {
+	if g.Branch == "" {
+		return "master"
+	}
+	return g.Branch
+}
+
+func (g *GitPluginDownloadCounter) git(args ...string) (output string, err error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	// the identity is required by the commit and merge commands
+	options := []string{"-c", "user.name=mirror-proxy", "-c", "user.email=mirror-proxy@jenkins-zh.cn"}
+	if g.Username != "" {
+		// the empty one clears the helpers of the system and global config
+		options = append(options, "-c", "credential.helper=", "-c", "credential.helper="+gitCredentialHelper)
+	}
+	cmd := exec.Command("git", append(options, args...)...)
+	cmd.Dir = g.Path
+	// the messages of git are matched, so they should not be translated
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "GIT_TERMINAL_PROMPT=0",
+		"MIRROR_PROXY_SYNC_USERNAME="+g.Username, "MIRROR_PROXY_SYNC_PASSWORD="+g.Password)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("failed to run git %s: %v, %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	output = strings.TrimSpace(stdout.String())
+	return
+}
@@ -0,0 +1,151 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+var _ = Describe("GitPluginDownloadCounter sync", func() {
+	var (
+		dir      string
+		remote   string
+		counterA *server.GitPluginDownloadCounter
+		counterB *server.GitPluginDownloadCounter
+		err      error
+	)
+
+	count := func(counter *server.GitPluginDownloadCounter, plugin string) int64 {
+		data, findErr := counter.FindPluginData("2020", plugin)
+		Expect(findErr).NotTo(HaveOccurred())
+		return data.Data["2020-01-01"]
+	}
+
+	add := func(counter *server.GitPluginDownloadCounter, plugin, provider string, n int64) {
+		Expect(counter.SaveCounts([]server.DownloadCount{{
+			Plugin: plugin, Provider: provider, Date: "2020-01-01", Count: n,
+		}})).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "git-sync")
+		Expect(err).NotTo(HaveOccurred())
+
+		remote = path.Join(dir, "remote.git")
+		Expect(exec.Command("git", "init", "--bare", remote).Run()).To(Succeed())
+
+		counterA = &server.GitPluginDownloadCounter{Path: path.Join(dir, "a"), Remote: remote}
+		counterB = &server.GitPluginDownloadCounter{Path: path.Join(dir, "b"), Remote: remote}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("without remote", func() {
+		counter := &server.GitPluginDownloadCounter{Path: path.Join(dir, "c")}
+		Expect(counter.Sync()).NotTo(Succeed())
+	})
+
+	It("sync with an empty remote", func() {
+		Expect(counterA.Sync()).To(Succeed())
+		Expect(counterA.Push()).To(Succeed())
+	})
+
+	It("pull the data from the remote", func() {
+		add(counterA, "git", "tsinghua", 3)
+		Expect(counterA.Push()).To(Succeed())
+
+		Expect(counterB.Sync()).To(Succeed())
+		Expect(count(counterB, "git")).To(Equal(int64(3)))
+
+		// fast-forward
+		add(counterA, "git", "tsinghua", 1)
+		Expect(counterA.Push()).To(Succeed())
+		Expect(counterB.Sync()).To(Succeed())
+		Expect(count(counterB, "git")).To(Equal(int64(4)))
+	})
+
+	It("merge the counts of replicas", func() {
+		add(counterA, "git", "tsinghua", 3)
+		Expect(counterA.Push()).To(Succeed())
+		Expect(counterB.Sync()).To(Succeed())
+
+		add(counterA, "git", "tsinghua", 1)
+		add(counterB, "git", "huawei", 2)
+		add(counterB, "maven", "", 5)
+		Expect(counterA.Push()).To(Succeed())
+		Expect(counterB.Push()).To(Succeed())
+		Expect(counterA.Sync()).To(Succeed())
+
+		for _, counter := range []*server.GitPluginDownloadCounter{counterA, counterB} {
+			Expect(count(counter, "git")).To(Equal(int64(6)))
+			Expect(count(counter, "maven")).To(Equal(int64(5)))
+
+			data, findErr := counter.FindPluginData("2020", "git")
+			Expect(findErr).NotTo(HaveOccurred())
+			Expect(data.Providers["tsinghua"]["2020-01-01"]).To(Equal(int64(4)))
+			Expect(data.Providers["huawei"]["2020-01-01"]).To(Equal(int64(2)))
+		}
+	})
+
+	It("push to a HTTP repository with the credential", func() {
+		gitPath, lookErr := exec.LookPath("git")
+		Expect(lookErr).NotTo(HaveOccurred())
+		backend := &cgi.Handler{
+			Path: gitPath,
+			Args: []string{"http-backend"},
+			Env:  []string{"GIT_PROJECT_ROOT=" + dir, "GIT_HTTP_EXPORT_ALL=1"},
+		}
+		gitServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if username, password, ok := r.BasicAuth(); !ok || username != "bot" || password != "secret-token" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// http-backend needs it to accept the pushing
+			r.Header.Set("Remote-User", "bot")
+			backend.ServeHTTP(w, r)
+		}))
+		defer gitServer.Close()
+		Expect(exec.Command("git", "-C", remote, "config", "http.receivepack", "true").Run()).To(Succeed())
+
+		counter := &server.GitPluginDownloadCounter{Path: path.Join(dir, "c"), Remote: gitServer.URL + "/remote.git",
+			Username: "bot", Password: "secret-token"}
+		add(counter, "git", "tsinghua", 1)
+		Expect(counter.Push()).To(Succeed())
+		Expect(counterB.Sync()).To(Succeed())
+		Expect(count(counterB, "git")).To(Equal(int64(1)))
+
+		config, readErr := ioutil.ReadFile(path.Join(dir, "c", ".git", "config"))
+		Expect(readErr).NotTo(HaveOccurred())
+		Expect(string(config)).NotTo(ContainSubstring("secret-token"))
+
+		counter.Password = "wrong"
+		pushErr := counter.Push()
+		Expect(pushErr).To(HaveOccurred())
+		Expect(pushErr.Error()).NotTo(ContainSubstring("secret-token"))
+	})
+
+	It("push periodically", func() {
+		add(counterA, "git", "tsinghua", 1)
+		counterA.StartSync(10 * time.Millisecond)
+		defer counterA.StopSync()
+
+		Eventually(func() error {
+			if syncErr := counterB.Sync(); syncErr != nil {
+				return syncErr
+			}
+			_, findErr := counterB.FindPluginData("2020", "git")
+			return findErr
+		}, "5s").Should(Succeed())
+	})
+})
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ProbeKindProvider is the kind of a mirror provider target
+	ProbeKindProvider = "provider"
+	// ProbeKindJSONServer is the kind of a JSON server target
+	ProbeKindJSONServer = "jsonServer"
+)
+
+// ProbeTarget represents a target which needs to be checked
+type ProbeTarget struct {
+	Kind string
+	// Name is the name of provider, or the URL of a JSON server
+	Name string
+	URL  string
+}
+
+// TargetStatus is the health status of a probe target
+type TargetStatus struct {
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Healthy    bool      `json:"healthy"`
+	StatusCode int       `json:"statusCode"`
+	Error      string    `json:"error,omitempty"`
+	LastCheck  time.Time `json:"lastCheck"`
+}
+
+// HealthChecker probes the providers and JSON servers periodically
+type HealthChecker struct {
+	Interval time.Duration
+	Client   *http.Client
+	Targets  func() []ProbeTarget
+
+	lock   sync.RWMutex
+	status map[string]TargetStatus
+	stop   chan struct{}
+}
+
+// NewHealthChecker creates a health checker
+func NewHealthChecker(interval, timeout time.Duration, targets func() []ProbeTarget) *HealthChecker {
+	return &HealthChecker{
+		Interval: interval,
+		Client:   &http.Client{Timeout: timeout},
+		Targets:  targets,
+		status:   map[string]TargetStatus{},
+	}
+}
+
+// Start probes all the targets in the background until Stop is called
+func (h *HealthChecker) Start() {
+	h.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(h.Interval)
+		defer ticker.Stop()
+
+		h.CheckAll()
+		for {
+			select {
+			case <-ticker.C:
+				h.CheckAll()
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background probing
+func (h *HealthChecker) Stop() {
+	if h.stop != nil {
+		close(h.stop)
+	}
+}
+
+// CheckAll probes all the targets once
+func (h *HealthChecker) CheckAll() {
+	for _, target := range h.Targets() {
+		status := h.Check(target)
+
+		h.lock.Lock()
+		if h.status == nil {
+			h.status = map[string]TargetStatus{}
+		}
+		h.status[statusKey(target.Kind, target.Name)] = status
+		h.lock.Unlock()
+	}
+}
+
+// Check sends a HEAD request to the target
+func (h *HealthChecker) Check(target ProbeTarget) (status TargetStatus) {
+	status = TargetStatus{
+		Kind:      target.Kind,
+		Name:      target.Name,
+		URL:       target.URL,
+		LastCheck: time.Now(),
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Head(target.URL)
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+	_ = response.Body.Close()
+
+	status.StatusCode = response.StatusCode
+	if response.StatusCode < http.StatusBadRequest {
+		status.Healthy = true
+	} else {
+		status.Error = fmt.Sprintf("unexpected status code: %d", response.StatusCode)
+	}
+	return
+}
+
+// IsHealthy returns false only if the target was checked and it is unhealthy
+func (h *HealthChecker) IsHealthy(kind, name string) bool {
+	if h == nil {
+		return true
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	status, ok := h.status[statusKey(kind, name)]
+	return !ok || status.Healthy
+}
+
+// GetStatus returns the status of all checked targets
+func (h *HealthChecker) GetStatus() (result []TargetStatus) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	result = make([]TargetStatus, 0, len(h.status))
+	for _, status := range h.status {
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return statusKey(result[i].Kind, result[i].Name) < statusKey(result[j].Kind, result[j].Name)
+	})
+	return
+}
+
+// PickHealthy returns the first healthy candidate, or the first one if all of them are unhealthy
+func (h *HealthChecker) PickHealthy(kind string, candidates ...string) (name string) {
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+
+		if name == "" {
+			name = candidate
+		}
+
+		if h.IsHealthy(kind, candidate) {
+			return candidate
+		}
+	}
+	return
+}
+
+func statusKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// GetProbeTargets returns all the providers and JSON servers which need to be checked.
+// The providers without a mirror URL are checked by their update center in the default JSON server
+func (o *ServerOptions) GetProbeTargets() (targets []ProbeTarget) {
+	defaultJSONServer := o.GetDefaultJSONServer()
+	registry := GetProviderRegistry()
+	names := registry.Names()
+	if o.DefaultProvider != "" && !registry.Has(o.DefaultProvider) {
+		names = append(names, o.DefaultProvider)
+	}
+	for _, name := range names {
+		target := ProbeTarget{Kind: ProbeKindProvider, Name: name}
+		if provider, ok := registry.FindMirror(name); ok {
+			target.URL = provider.GetArtifactURL(o.HealthCheckProviderPath)
+		} else if defaultJSONServer != "" {
+			target.URL = fmt.Sprintf("%s/%s/update-center.json", defaultJSONServer, name)
+		} else {
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	jsonServers := []string{defaultJSONServer}
+	for _, jsonServer := range GetJSONServers() {
+		jsonServers = append(jsonServers, jsonServer)
+	}
+	for _, jsonServer := range jsonServers {
+		if jsonServer == "" {
+			continue
+		}
+
+		targets = append(targets, ProbeTarget{
+			Kind: ProbeKindJSONServer,
+			Name: jsonServer,
+			URL:  fmt.Sprintf("%s/%s/update-center.json", jsonServer, o.DefaultProvider),
+		})
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+var _ = Describe("HealthChecker", func() {
+	var (
+		healthyServer   *httptest.Server
+		unhealthyServer *httptest.Server
+		checker         *server.HealthChecker
+	)
+
+	BeforeEach(func() {
+		healthyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		unhealthyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+
+		checker = server.NewHealthChecker(time.Minute, time.Second, func() []server.ProbeTarget {
+			return []server.ProbeTarget{{
+				Kind: server.ProbeKindProvider,
+				Name: "good",
+				URL:  healthyServer.URL,
+			}, {
+				Kind: server.ProbeKindProvider,
+				Name: "bad",
+				URL:  unhealthyServer.URL,
+			}}
+		})
+	})
+
+	AfterEach(func() {
+		healthyServer.Close()
+		unhealthyServer.Close()
+	})
+
+	It("unchecked targets are healthy", func() {
+		Expect(checker.IsHealthy(server.ProbeKindProvider, "good")).To(BeTrue())
+		Expect(checker.IsHealthy(server.ProbeKindProvider, "bad")).To(BeTrue())
+		Expect(checker.GetStatus()).To(BeEmpty())
+	})
+
+	It("a nil checker considers all targets are healthy", func() {
+		var nilChecker *server.HealthChecker
+		Expect(nilChecker.IsHealthy(server.ProbeKindProvider, "bad")).To(BeTrue())
+		Expect(nilChecker.PickHealthy(server.ProbeKindProvider, "bad", "good")).To(Equal("bad"))
+	})
+
+	Context("after checking", func() {
+		JustBeforeEach(func() {
+			checker.CheckAll()
+		})
+
+		It("should have the status", func() {
+			Expect(checker.IsHealthy(server.ProbeKindProvider, "good")).To(BeTrue())
+			Expect(checker.IsHealthy(server.ProbeKindProvider, "bad")).To(BeFalse())
+
+			status := checker.GetStatus()
+			Expect(len(status)).To(Equal(2))
+			Expect(status[0].Name).To(Equal("bad"))
+			Expect(status[0].StatusCode).To(Equal(http.StatusBadGateway))
+			Expect(status[0].Error).NotTo(BeEmpty())
+		})
+
+		It("PickHealthy should skip the unhealthy one", func() {
+			Expect(checker.PickHealthy(server.ProbeKindProvider, "bad", "good")).To(Equal("good"))
+			Expect(checker.PickHealthy(server.ProbeKindProvider, "", "bad")).To(Equal("bad"))
+		})
+	})
+})
+
+var _ = Describe("failover", func() {
+	var (
+		opt    *server.ServerOptions
+		down   *httptest.Server
+		up     *httptest.Server
+		remote *url.URL
+	)
+
+	BeforeEach(func() {
+		down = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		up = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		viper.Set("providerMirrors", map[string]interface{}{
+			"down": map[string]interface{}{"url": down.URL},
+			"up":   map[string]interface{}{"url": up.URL},
+		})
+		viper.Set("jsonServers", map[string]string{
+			"down": down.URL,
+			"up":   up.URL,
+		})
+
+		opt = &server.ServerOptions{
+			DefaultProvider:   "down",
+			DefaultJSONServer: down.URL,
+		}
+		opt.HealthChecker = server.NewHealthChecker(time.Minute, time.Second, opt.GetProbeTargets)
+		opt.HealthChecker.CheckAll()
+
+		var err error
+		remote, err = url.Parse("https://updates.jenkins.io/current/update-center.json")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		viper.Reset()
+		down.Close()
+		up.Close()
+	})
+
+	It("GetProvider should fallback to the healthy one", func() {
+		Expect(opt.GetProvider("down").Name).To(Equal("up"))
+	})
+
+	It("GetProviderURL should fallback to the healthy JSON server and provider", func() {
+		targetURL := opt.GetProviderURL(remote, server.UpdateCenterQuery{})
+		Expect(targetURL).To(Equal(up.URL + "/up/current/update-center.json"))
+	})
+
+	Context("a provider without mirror URL", func() {
+		BeforeEach(func() {
+			viper.Set("providers", []string{"huawei"})
+			opt.DefaultProvider = "huawei"
+			opt.HealthChecker.CheckAll()
+		})
+
+		It("should be checked by the default JSON server", func() {
+			Expect(opt.HealthChecker.IsHealthy(server.ProbeKindProvider, "huawei")).To(BeFalse())
+			Expect(opt.GetProbeTargets()).To(ContainElement(server.ProbeTarget{
+				Kind: server.ProbeKindProvider,
+				Name: "huawei",
+				URL:  down.URL + "/huawei/update-center.json",
+			}))
+		})
+
+		It("GetProviderURL should fallback to the healthy provider", func() {
+			targetURL := opt.GetProviderURL(remote, server.UpdateCenterQuery{})
+			Expect(targetURL).To(Equal(up.URL + "/up/current/update-center.json"))
+		})
+	})
+})
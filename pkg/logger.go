@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jenkins-zh/mirror-proxy/pkg/helper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// LogFormatJSON writes a JSON object per line
+	LogFormatJSON = "json"
+	// LogFormatConsole writes the human friendly lines
+	LogFormatConsole = "console"
+)
+
+var logger, _ = NewLogger("info", LogFormatJSON)
+
+// GetLogger returns the logger of the server
+func GetLogger() *zap.Logger {
+	return logger
+}
+
+// SetLogger replaces the logger of the server
+func SetLogger(newLogger *zap.Logger) {
+	logger = newLogger
+}
+
+// NewLogger creates a logger which writes to the stderr with the level and format
+func NewLogger(level, format string) (newLogger *zap.Logger, err error) {
+	var config zap.Config
+	switch format {
+	case LogFormatJSON, "":
+		config = zap.NewProductionConfig()
+		config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case LogFormatConsole:
+		config = zap.NewDevelopmentConfig()
+	default:
+		err = fmt.Errorf("unknown log format: %s", format)
+		return
+	}
+
+	if err = config.Level.UnmarshalText([]byte(level)); err != nil {
+		return
+	}
+	// the sampling drops the request logs
+	config.Sampling = nil
+	return config.Build()
+}
+
+// NewLogPrinter adapts the logger to the printer which logs the messages in the given level
+func NewLogPrinter(logger *zap.Logger, level zapcore.Level) helper.Printer {
+	return &logPrinter{logger: logger.WithOptions(zap.AddCallerSkip(2)), level: level}
+}
+
+type logPrinter struct {
+	logger *zap.Logger
+	level  zapcore.Level
+}
+
+// Println logs the message which is formatted like fmt.Println
+func (p *logPrinter) Println(a ...interface{}) {
+	if entry := p.logger.Check(p.level, strings.TrimSuffix(fmt.Sprintln(a...), "\n")); entry != nil {
+		entry.Write()
+	}
+}
+
+// RequestFields are the fields of a request which are resolved by the handlers
+type RequestFields struct {
+	RequestID  string
+	Provider   string
+	JSONServer string
+	Target     string
+}
+
+type requestFieldsKey struct{}
+
+// GetRequestFields returns the fields of the request, they will be logged when the request is done
+func GetRequestFields(r *http.Request) *RequestFields {
+	if fields, ok := r.Context().Value(requestFieldsKey{}).(*RequestFields); ok {
+		return fields
+	}
+	return &RequestFields{}
+}
+
+// logRequest logs the request after it's handled, the request ID comes from the header X-Request-ID if it exists
+func logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		fields := &RequestFields{RequestID: requestID}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestFieldsKey{}, fields)))
+
+		logger.Info("request",
+			zap.String("request_id", requestID),
+			zap.String("method", r.Method),
+			zap.String("uri", r.RequestURI),
+			zap.String("client_ip", GetClientIP(r)),
+			zap.Int("status", recorder.status),
+			zap.String("provider", fields.Provider),
+			zap.String("json_server", fields.JSONServer),
+			zap.String("target", fields.Target),
+			zap.Duration("latency", time.Since(start)))
+	})
+}
+
+// GetClientIP returns the first address of X-Forwarded-For, or the remote address
+func GetClientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func newRequestID() string {
+	data := make([]byte, 8)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// statusRecorder records the status code and the body size of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+// WriteHeader records the status code
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the size of the body
+func (s *statusRecorder) Write(data []byte) (n int, err error) {
+	n, err = s.ResponseWriter.Write(data)
+	s.size += int64(n)
+	return
+}
@@ -0,0 +1,91 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("NewLogger", func() {
+	It("supported formats", func() {
+		_, err := server.NewLogger("debug", server.LogFormatJSON)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = server.NewLogger("warn", server.LogFormatConsole)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("invalid inputs", func() {
+		_, err := server.NewLogger("info", "xml")
+		Expect(err).To(HaveOccurred())
+		_, err = server.NewLogger("fake", server.LogFormatJSON)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("request logging", func() {
+	var (
+		original *zap.Logger
+		logs     *observer.ObservedLogs
+	)
+
+	BeforeEach(func() {
+		var core zapcore.Core
+		core, logs = observer.New(zapcore.DebugLevel)
+		original = server.GetLogger()
+		server.SetLogger(zap.New(core))
+	})
+
+	AfterEach(func() {
+		server.SetLogger(original)
+	})
+
+	It("printer", func() {
+		server.NewLogPrinter(server.GetLogger(), zapcore.WarnLevel).Println("cannot", "write")
+		Expect(logs.All()).To(HaveLen(1))
+		Expect(logs.All()[0].Message).To(Equal("cannot write"))
+		Expect(logs.All()[0].Level).To(Equal(zapcore.WarnLevel))
+	})
+
+	It("log the request with the resolved fields", func() {
+		handler := server.AddContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields := server.GetRequestFields(r)
+			fields.Provider, fields.Target = "tsinghua", "https://a.com/git.hpi"
+			w.WriteHeader(http.StatusMovedPermanently)
+		}), &server.ServerOptions{})
+
+		request := httptest.NewRequest(http.MethodGet, "/jenkins/plugins/git.hpi", nil)
+		request.Header.Set("X-Request-ID", "abc")
+		request.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("X-Request-ID")).To(Equal("abc"))
+		entries := logs.FilterMessage("request").All()
+		Expect(entries).To(HaveLen(1))
+
+		fields := entries[0].ContextMap()
+		Expect(fields["request_id"]).To(Equal("abc"))
+		Expect(fields["client_ip"]).To(Equal("1.2.3.4"))
+		Expect(fields["status"]).To(Equal(int64(http.StatusMovedPermanently)))
+		Expect(fields["provider"]).To(Equal("tsinghua"))
+		Expect(fields["target"]).To(Equal("https://a.com/git.hpi"))
+		Expect(fields).To(HaveKey("latency"))
+	})
+
+	It("generate the request ID", func() {
+		handler := server.AddContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			&server.ServerOptions{})
+
+		request := httptest.NewRequest(http.MethodGet, "/status", nil)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("X-Request-ID")).To(HaveLen(16))
+		Expect(logs.FilterMessage("request").All()[0].ContextMap()["client_ip"]).To(Equal("192.0.2.1"))
+	})
+})
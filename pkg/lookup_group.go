@@ -0,0 +1,42 @@
+package pkg
+
+import "sync"
+
+// lookupGroup coalesces the concurrent lookups with the same key, the callers share the result of the in-flight one
+type lookupGroup struct {
+	lock    sync.Mutex
+	lookups map[string]*lookup
+}
+
+type lookup struct {
+	wait  sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+// Do runs the function once for the concurrent callers with the same key, shared is true for the callers which wait
+func (g *lookupGroup) Do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.lock.Lock()
+	if g.lookups == nil {
+		g.lookups = map[string]*lookup{}
+	}
+	if current, ok := g.lookups[key]; ok {
+		g.lock.Unlock()
+		current.wait.Wait()
+		return current.value, current.err, true
+	}
+
+	current := &lookup{}
+	current.wait.Add(1)
+	g.lookups[key] = current
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.lookups, key)
+		g.lock.Unlock()
+		current.wait.Done()
+	}()
+	current.value, current.err = fn()
+	return current.value, current.err, false
+}
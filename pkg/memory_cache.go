@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCacheFlushDelay is the delay of writing the saved items into the backend
+const DefaultCacheFlushDelay = time.Second
+
+// MemoryCacheServer keeps the cache items in memory, it's safe for the concurrent access.
+// The saved items are written to the backend in the background if it's given, and loaded from it when the cache is created
+type MemoryCacheServer struct {
+	// TTL is the time to live of the saved items, they never expire if it's zero
+	TTL     time.Duration
+	Backend CacheServer
+	// FlushDelay is the delay of writing the saved items into the backend, the items saved in the meantime
+	// are written together. DefaultCacheFlushDelay is used if it's zero
+	FlushDelay time.Duration
+
+	lock  sync.RWMutex
+	items map[string]CacheItem
+	// dirty are the keys which are not written into the backend yet
+	dirty      map[string]bool
+	flushTimer *time.Timer
+	// backendLock serializes the writes of the backend, the file cannot be written concurrently
+	backendLock sync.Mutex
+}
+
+// NewMemoryCacheServer creates a cache, the items are loaded from the backend if it's not nil.
+// The cache works even if it cannot load the items
+func NewMemoryCacheServer(ttl time.Duration, backend CacheServer) (cache *MemoryCacheServer, err error) {
+	cache = &MemoryCacheServer{
+		TTL:     ttl,
+		Backend: backend,
+		items:   map[string]CacheItem{},
+		dirty:   map[string]bool{},
+	}
+	if backend == nil {
+		return
+	}
+
+	var values map[string]string
+	if values, err = backend.List(); err != nil {
+		return
+	}
+	for key := range values {
+		if item, ok := backend.LoadItem(key); ok {
+			cache.items[key] = item
+		}
+	}
+	return
+}
+
+// Load returns the value of a key, it's empty if the item does not exist, expired or negative
+func (c *MemoryCacheServer) Load(key string) (val string) {
+	if item, ok := c.LoadItem(key); ok && !item.Negative && !item.Expired() {
+		val = item.Value
+	}
+	return
+}
+
+// Save saves the value with the TTL of the cache
+func (c *MemoryCacheServer) Save(key string, val string) error {
+	return c.SaveItem(key, CacheItem{Value: val, FetchTime: time.Now(), TTL: c.TTL})
+}
+
+// LoadItem returns the item from memory
+func (c *MemoryCacheServer) LoadItem(key string) (item CacheItem, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	item, ok = c.items[key]
+	return
+}
+
+// SaveItem saves the item into memory, it's written into the backend after the FlushDelay
+func (c *MemoryCacheServer) SaveItem(key string, item CacheItem) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items[key] = item
+
+	if c.Backend != nil {
+		c.dirty[key] = true
+		if c.flushTimer == nil {
+			c.flushTimer = time.AfterFunc(c.getFlushDelay(), func() {
+				if flushErr := c.Flush(); flushErr != nil {
+					logger.Warn("cannot write the cache items into the backend", zap.Error(flushErr))
+				}
+			})
+		}
+	}
+	return
+}
+
+// Flush writes the saved items into the backend, the ones which are not written yet should be flushed before exiting.
+// The expired items, including the failed lookups, are removed from memory and the backend
+func (c *MemoryCacheServer) Flush() (err error) {
+	c.backendLock.Lock()
+	defer c.backendLock.Unlock()
+
+	c.lock.Lock()
+	var expired []string
+	for key, item := range c.items {
+		if item.Expired() {
+			expired = append(expired, key)
+			delete(c.items, key)
+			delete(c.dirty, key)
+		}
+	}
+	if c.Backend == nil {
+		c.lock.Unlock()
+		return
+	}
+
+	items := make(map[string]CacheItem, len(c.dirty))
+	for key := range c.dirty {
+		if item, ok := c.items[key]; ok {
+			items[key] = item
+		}
+	}
+	c.dirty = map[string]bool{}
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+		c.flushTimer = nil
+	}
+	c.lock.Unlock()
+
+	if len(items) > 0 {
+		err = saveCacheItems(c.Backend, items)
+	}
+	// all the items are removed if there is no key
+	if err == nil && len(expired) > 0 {
+		err = c.Backend.Clear(expired...)
+	}
+	return
+}
+
+func (c *MemoryCacheServer) getFlushDelay() time.Duration {
+	if c.FlushDelay <= 0 {
+		return DefaultCacheFlushDelay
+	}
+	return c.FlushDelay
+}
+
+// List returns all the items in memory, the negative ones are not included
+func (c *MemoryCacheServer) List() (items map[string]string, err error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	items = make(map[string]string, len(c.items))
+	for key, item := range c.items {
+		if !item.Negative {
+			items[key] = item.Value
+		}
+	}
+	return
+}
+
+// Clear removes the given keys, or all the items if there is no key
+func (c *MemoryCacheServer) Clear(keys ...string) (err error) {
+	// the removed items should not be written by a flush in progress
+	c.backendLock.Lock()
+	defer c.backendLock.Unlock()
+
+	c.lock.Lock()
+	if len(keys) == 0 {
+		c.items = map[string]CacheItem{}
+		c.dirty = map[string]bool{}
+	}
+	for _, key := range keys {
+		delete(c.items, key)
+		delete(c.dirty, key)
+	}
+	c.lock.Unlock()
+
+	if c.Backend != nil {
+		err = c.Backend.Clear(keys...)
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package pkg_test
+
+import (
+	"fmt"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ = Describe("MemoryCacheServer", func() {
+	var (
+		backend     *server.FileSystemCacheServer
+		cacheServer *server.MemoryCacheServer
+		err         error
+	)
+
+	BeforeEach(func() {
+		backend = &server.FileSystemCacheServer{FileName: "cache.yaml", TTL: time.Hour}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll("cache.yaml")).To(Succeed())
+	})
+
+	It("without backend", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cacheServer.Save("2.263.1", "url")).To(Succeed())
+		Expect(cacheServer.Load("2.263.1")).To(Equal("url"))
+		item, ok := cacheServer.LoadItem("2.263.1")
+		Expect(ok).To(BeTrue())
+		Expect(item.TTL).To(Equal(time.Hour))
+
+		Expect(cacheServer.SaveItem("2.264", server.CacheItem{Value: "failed", FetchTime: time.Now(), TTL: time.Minute, Negative: true})).To(Succeed())
+		Expect(cacheServer.Load("2.264")).To(BeEmpty())
+		Expect(cacheServer.List()).To(Equal(map[string]string{"2.263.1": "url"}))
+		_, err = os.Stat("cache.yaml")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		Expect(cacheServer.Clear()).To(Succeed())
+		Expect(cacheServer.List()).To(BeEmpty())
+	})
+
+	It("write through to the backend", func() {
+		Expect(backend.Save("2.263.1", "old")).To(Succeed())
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cacheServer.Load("2.263.1")).To(Equal("old"))
+
+		Expect(cacheServer.Save("2.264", "new")).To(Succeed())
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.Load("2.264")).To(Equal("new"))
+
+		Expect(cacheServer.Clear("2.263.1")).To(Succeed())
+		Expect(backend.List()).To(Equal(map[string]string{"2.264": "new"}))
+	})
+
+	It("write the saved items together in the background", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		cacheServer.FlushDelay = time.Hour
+
+		Expect(cacheServer.Save("2.263.1", "url-1")).To(Succeed())
+		Expect(cacheServer.Save("2.264", "url-2")).To(Succeed())
+		_, err = os.Stat("cache.yaml")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.List()).To(Equal(map[string]string{"2.263.1": "url-1", "2.264": "url-2"}))
+	})
+
+	It("keep the items which are removed from the backend by others", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cacheServer.Save("2.263.1", "url-1")).To(Succeed())
+		Expect(cacheServer.Flush()).To(Succeed())
+
+		// such as the cache clear command
+		Expect(backend.Clear()).To(Succeed())
+		Expect(cacheServer.Save("2.264", "url-2")).To(Succeed())
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.List()).To(Equal(map[string]string{"2.264": "url-2"}))
+	})
+
+	It("do not write the cleared items", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		cacheServer.FlushDelay = time.Hour
+
+		Expect(cacheServer.Save("2.263.1", "url-1")).To(Succeed())
+		Expect(cacheServer.Clear("2.263.1")).To(Succeed())
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.List()).To(BeEmpty())
+	})
+
+	It("remove the expired items", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		cacheServer.FlushDelay = time.Hour
+
+		expired := time.Now().Add(-2 * time.Minute)
+		Expect(cacheServer.SaveItem("2.263.1", server.CacheItem{Value: "url", FetchTime: expired, TTL: time.Minute})).To(Succeed())
+		Expect(cacheServer.SaveItem("2.264", server.CacheItem{Value: "failed", FetchTime: expired, TTL: time.Minute, Negative: true})).To(Succeed())
+		Expect(cacheServer.Save("2.265", "url")).To(Succeed())
+		Expect(backend.SaveItem("2.266", server.CacheItem{Value: "url", FetchTime: expired, TTL: time.Minute})).To(Succeed())
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.List()).To(Equal(map[string]string{"2.265": "url", "2.266": "url"}))
+
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cacheServer.Flush()).To(Succeed())
+		Expect(backend.List()).To(Equal(map[string]string{"2.265": "url"}))
+		_, ok := cacheServer.LoadItem("2.264")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("concurrent access", func() {
+		cacheServer, err = server.NewMemoryCacheServer(time.Hour, backend)
+		Expect(err).NotTo(HaveOccurred())
+
+		var wait sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wait.Add(1)
+			go func(i int) {
+				defer wait.Done()
+				version := fmt.Sprintf("2.%d", i)
+				_ = cacheServer.Save(version, "url-"+version)
+				_ = cacheServer.Load(version)
+				_, _ = cacheServer.List()
+			}(i)
+		}
+		wait.Wait()
+		Expect(cacheServer.Flush()).To(Succeed())
+
+		items, listErr := backend.List()
+		Expect(listErr).NotTo(HaveOccurred())
+		Expect(items).To(HaveLen(20))
+		Expect(cacheServer.List()).To(Equal(items))
+	})
+})
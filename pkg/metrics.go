@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OtherPluginLabel is the plugin label of the downloads whose plugin is unknown
+const OtherPluginLabel = "other"
+
+// Metrics holds the counters which are exposed in the Prometheus text format
+type Metrics struct {
+	// KnownPlugin tells if the plugin name could be a label, the unknown ones are counted as OtherPluginLabel.
+	// The names come from the request paths, so they should be checked to keep the count of the series bounded
+	KnownPlugin func(name string) bool
+
+	lock sync.Mutex
+
+	providerRedirects   map[string]int64
+	jsonServerRedirects map[string]int64
+	pluginDownloads     map[[2]string]int64
+}
+
+// NewMetrics creates the metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		providerRedirects:   map[string]int64{},
+		jsonServerRedirects: map[string]int64{},
+		pluginDownloads:     map[[2]string]int64{},
+	}
+}
+
+// RecordRedirect counts a redirect of the update center, it does nothing if the metrics is nil
+func (m *Metrics) RecordRedirect(provider, jsonServer string) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.providerRedirects[provider]++
+	m.jsonServerRedirects[jsonServer]++
+}
+
+// RecordPluginDownload counts a download of a plugin, it does nothing if the metrics is nil
+func (m *Metrics) RecordPluginDownload(plugin, provider string) {
+	if m == nil {
+		return
+	}
+
+	if m.KnownPlugin != nil && !m.KnownPlugin(plugin) {
+		plugin = OtherPluginLabel
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pluginDownloads[[2]string{plugin, provider}]++
+}
+
+// WriteTo writes all the counters in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	buf := &strings.Builder{}
+	writeMetricHeader(buf, "mirror_proxy_update_center_redirects_total",
+		"The count of the update center redirects per provider")
+	for _, provider := range sortedCountKeys(m.providerRedirects) {
+		fmt.Fprintf(buf, "mirror_proxy_update_center_redirects_total{provider=\"%s\"} %d\n",
+			escapeLabelValue(provider), m.providerRedirects[provider])
+	}
+
+	writeMetricHeader(buf, "mirror_proxy_json_server_redirects_total",
+		"The count of the update center redirects per JSON server")
+	for _, jsonServer := range sortedCountKeys(m.jsonServerRedirects) {
+		fmt.Fprintf(buf, "mirror_proxy_json_server_redirects_total{json_server=\"%s\"} %d\n",
+			escapeLabelValue(jsonServer), m.jsonServerRedirects[jsonServer])
+	}
+
+	writeMetricHeader(buf, "mirror_proxy_plugin_downloads_total",
+		"The count of the plugin downloads per plugin and provider")
+	keys := make([][2]string, 0, len(m.pluginDownloads))
+	for key := range m.pluginDownloads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(buf, "mirror_proxy_plugin_downloads_total{plugin=\"%s\",provider=\"%s\"} %d\n",
+			escapeLabelValue(key[0]), escapeLabelValue(key[1]), m.pluginDownloads[key])
+	}
+
+	var written int
+	written, err = io.WriteString(w, buf.String())
+	n = int64(written)
+	return
+}
+
+func writeMetricHeader(buf *strings.Builder, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+}
+
+func sortedCountKeys(counts map[string]int64) (keys []string) {
+	keys = make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
@@ -0,0 +1,67 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("without any counts", func() {
+		buf := &bytes.Buffer{}
+		_, err := server.NewMetrics().WriteTo(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(Equal(`# HELP mirror_proxy_update_center_redirects_total The count of the update center redirects per provider
+# TYPE mirror_proxy_update_center_redirects_total counter
+# HELP mirror_proxy_json_server_redirects_total The count of the update center redirects per JSON server
+# TYPE mirror_proxy_json_server_redirects_total counter
+# HELP mirror_proxy_plugin_downloads_total The count of the plugin downloads per plugin and provider
+# TYPE mirror_proxy_plugin_downloads_total counter
+`))
+	})
+
+	It("with counts", func() {
+		metrics := server.NewMetrics()
+		metrics.RecordRedirect("tsinghua", "https://a.com")
+		metrics.RecordRedirect("tsinghua", "https://b.com")
+		metrics.RecordPluginDownload("git", "tsinghua")
+		metrics.RecordPluginDownload("git", "tsinghua")
+		metrics.RecordPluginDownload("a\"b", "huawei")
+
+		buf := &bytes.Buffer{}
+		_, err := metrics.WriteTo(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring(`mirror_proxy_update_center_redirects_total{provider="tsinghua"} 2
+`))
+		Expect(buf.String()).To(ContainSubstring(`mirror_proxy_json_server_redirects_total{json_server="https://a.com"} 1
+mirror_proxy_json_server_redirects_total{json_server="https://b.com"} 1
+`))
+		Expect(buf.String()).To(ContainSubstring(`mirror_proxy_plugin_downloads_total{plugin="a\"b",provider="huawei"} 1
+mirror_proxy_plugin_downloads_total{plugin="git",provider="tsinghua"} 2
+`))
+	})
+
+	It("count the unknown plugins as other", func() {
+		metrics := server.NewMetrics()
+		metrics.KnownPlugin = func(name string) bool {
+			return name == "git"
+		}
+		metrics.RecordPluginDownload("git", "tsinghua")
+		metrics.RecordPluginDownload("a", "tsinghua")
+		metrics.RecordPluginDownload("b", "tsinghua")
+
+		buf := &bytes.Buffer{}
+		_, err := metrics.WriteTo(buf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring(`mirror_proxy_plugin_downloads_total{plugin="git",provider="tsinghua"} 1
+mirror_proxy_plugin_downloads_total{plugin="other",provider="tsinghua"} 2
+`))
+	})
+
+	It("nil metrics", func() {
+		var metrics *server.Metrics
+		metrics.RecordRedirect("tsinghua", "https://a.com")
+		metrics.RecordPluginDownload("git", "tsinghua")
+	})
+})
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"io"
+
+	"github.com/spf13/cobra"
+)
+
+// MigrateOptions represents the options for migrating the download data
+type MigrateOptions struct {
+	From string
+	To   string
+}
+
+var migrateOptions MigrateOptions
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Import the year YAML files of the download data into the embedded database",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = migrateOptions.Run(cmd, args)
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().StringVarP(&migrateOptions.From, "from", "", "data",
+		"The directory of the year YAML files")
+	migrateCmd.Flags().StringVarP(&migrateOptions.To, "to", "", "data",
+		"The directory of the embedded database")
+}
+
+// Run imports all the year YAML files, the existing data of the same years will be replaced
+func (o *MigrateOptions) Run(cmd *cobra.Command, args []string) (err error) {
+	source := &GitPluginDownloadCounter{Path: o.From}
+
+	var dataArray []PluginDownloadData
+	if dataArray, err = source.ReadData(); err != nil {
+		return
+	}
+
+	var target PluginDownloadCounter
+	if target, err = NewPluginDownloadCounter(CounterTypeBolt, o.To); err != nil {
+		return
+	}
+	defer func() {
+		_ = target.(io.Closer).Close()
+	}()
+
+	for i := range dataArray {
+		data := dataArray[i]
+		if err = target.Save(&data); err != nil {
+			return
+		}
+		cmd.Printf("imported the data of year %s, %d plugins\n", data.Year, len(data.Plugins))
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+var _ = Describe("migrate command", func() {
+	var (
+		from string
+		to   string
+		err  error
+	)
+
+	BeforeEach(func() {
+		from, err = ioutil.TempDir("", "from")
+		Expect(err).NotTo(HaveOccurred())
+		to, err = ioutil.TempDir("", "to")
+		Expect(err).NotTo(HaveOccurred())
+
+		source := &server.GitPluginDownloadCounter{Path: from}
+		Expect(source.Save(&server.PluginDownloadData{
+			Year: "2019",
+			Plugins: map[string]server.PluginData{
+				"git": {Data: map[string]int64{"2019-01-01": 3}},
+			},
+		})).To(Succeed())
+		Expect(ioutil.WriteFile(from+"/other.yaml", []byte("fake"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(from)).To(Succeed())
+		Expect(os.RemoveAll(to)).To(Succeed())
+	})
+
+	It("should import the year files", func() {
+		rootCmd := server.GetRootCmd()
+		rootCmd.SetArgs([]string{"migrate", "--from", from, "--to", to})
+
+		buf := new(bytes.Buffer)
+		rootCmd.SetOutput(buf)
+		_, err = rootCmd.ExecuteC()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(buf.String()).To(ContainSubstring("imported the data of year 2019, 1 plugins"))
+
+		counter, err := server.NewBoltPluginDownloadCounter(to + "/plugins.db")
+		Expect(err).NotTo(HaveOccurred())
+		defer counter.Close()
+
+		data, err := counter.FindPluginData("2019", "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data).To(Equal(map[string]int64{"2019-01-01": 3}))
+	})
+})
@@ -2,21 +2,31 @@ package pkg
 
 import (
 	"fmt"
+	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 )
 
-// GitPluginDownloadCounter count the data by git
+// GitPluginDownloadCounter count the data by git.
+// The data files will be committed and pushed to the remote repository if it's set
 type GitPluginDownloadCounter struct {
 	Path     string
+	Remote   string
+	Branch   string
 	Username string
 	Password string
+
+	lock     sync.Mutex
+	stopSync chan struct{}
+	syncing  sync.WaitGroup
 }
 
-// PluginDownloadCounter
+// PluginDownloadCounter is the store of the plugin download data
 type PluginDownloadCounter interface {
 	ReadData() ([]PluginDownloadData, error)
 	FindByYear(year string) (*PluginDownloadData, error)
@@ -25,13 +35,75 @@ type PluginDownloadCounter interface {
 	FindPluginData(year, name string) (PluginData, error)
 
 	UpdateCenterCountIncrease(downloadData *PluginDownloadData) error
+
+	RecordPluginDownloadData(plugin, provider string) error
+	RecordUpdateCenterVisitData() error
+
+	// SaveCounts adds a batch of counts into the store
+	SaveCounts(counts []DownloadCount) error
+}
+
+const (
+	// CounterTypeGit stores the data as a YAML file per year
+	CounterTypeGit = "git"
+	// CounterTypeBolt stores the data into an embedded database
+	CounterTypeBolt = "bolt"
+)
+
+// NewPluginDownloadCounter creates a counter by type
+func NewPluginDownloadCounter(counterType, dataFilePath string) (counter PluginDownloadCounter, err error) {
+	return newPluginDownloadCounter(counterType, dataFilePath, false)
+}
+
+func newPluginDownloadCounter(counterType, dataFilePath string, readOnly bool) (counter PluginDownloadCounter, err error) {
+	switch counterType {
+	case CounterTypeGit, "":
+		counter = &GitPluginDownloadCounter{Path: dataFilePath}
+	case CounterTypeBolt:
+		if readOnly {
+			counter, err = OpenBoltPluginDownloadCounter(path.Join(dataFilePath, "plugins.db"), true, DefaultBoltOpenTimeout)
+		} else if err = os.MkdirAll(dataFilePath, 0751); err == nil {
+			counter, err = NewBoltPluginDownloadCounter(path.Join(dataFilePath, "plugins.db"))
+		}
+	default:
+		err = fmt.Errorf("unknown counter type: %s", counterType)
+	}
+	return
 }
 
 // ReadData get all data
 func (g *GitPluginDownloadCounter) ReadData() (dataArray []PluginDownloadData, err error) {
+	var files []os.FileInfo
+	if files, err = ioutil.ReadDir(g.Path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, file := range files {
+		year := strings.TrimSuffix(file.Name(), ".yaml")
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".yaml") || !isYear(year) {
+			continue
+		}
+
+		var downloadData *PluginDownloadData
+		if downloadData, err = g.FindByYear(year); err != nil {
+			return
+		}
+		if downloadData.Year == "" {
+			downloadData.Year = year
+		}
+		dataArray = append(dataArray, *downloadData)
+	}
 	return
 }
 
+func isYear(year string) bool {
+	_, err := time.Parse("2006", year)
+	return err == nil
+}
+
 // FindByYear returns the data by year
 func (g *GitPluginDownloadCounter) FindByYear(year string) (downloadData *PluginDownloadData, err error) {
 	dataFilePath := g.GetDataFilePath(year)
@@ -52,7 +124,7 @@ func (g *GitPluginDownloadCounter) FindByYear(year string) (downloadData *Plugin
 func (g *GitPluginDownloadCounter) Save(downloadData *PluginDownloadData) (err error) {
 	dataFilePath := g.GetDataFilePath(downloadData.Year)
 
-	fmt.Println("prepare to store", dataFilePath)
+	logger.Debug("prepare to store", zap.String("path", dataFilePath))
 
 	if err = os.MkdirAll(path.Dir(dataFilePath), 0751); err != nil {
 		return
@@ -84,7 +156,12 @@ func (g *GitPluginDownloadCounter) UpdateCenterCountIncrease(downloadData *Plugi
 	return
 }
 
+// PluginCountIncrease increases the count of a plugin in the given data
 func (g *GitPluginDownloadCounter) PluginCountIncrease(downloadData *PluginDownloadData, plugin string) (err error) {
+	return pluginCountIncrease(downloadData, plugin)
+}
+
+func pluginCountIncrease(downloadData *PluginDownloadData, plugin string) (err error) {
 	if center, ok := downloadData.Plugins[plugin]; ok {
 		if count, ok := center.Data[GetDate()]; ok {
 			center.Data[GetDate()] = count + 1
@@ -105,42 +182,46 @@ func (g *GitPluginDownloadCounter) PluginCountIncrease(downloadData *PluginDownl
 	return
 }
 
+// RecordPluginDownloadData increases the download count of a plugin
 func (g *GitPluginDownloadCounter) RecordPluginDownloadData(plugin, provider string) (err error) {
-	fmt.Println("plugin", plugin, "provider", provider)
-	var downloadData *PluginDownloadData
-	if downloadData, err = g.FindByYear(GetCurrentYear()); err != nil {
-		fmt.Println("cannot find by year", GetCurrentYear(), err)
-		downloadData = &PluginDownloadData{
-			Year: GetCurrentYear(),
-		}
-	}
-	fmt.Println(downloadData)
+	return g.SaveCounts([]DownloadCount{{Plugin: plugin, Provider: provider, Date: GetDate(), Count: 1}})
+}
 
-	if err = g.PluginCountIncrease(downloadData, plugin); err != nil {
-		fmt.Println(err)
-	}
+// RecordUpdateCenterVisitData increases the visit count of update center
+func (g *GitPluginDownloadCounter) RecordUpdateCenterVisitData() (err error) {
+	return g.SaveCounts([]DownloadCount{{Plugin: "update-center", Date: GetDate(), Count: 1}})
+}
+
+// SaveCounts adds a batch of counts, each year file will be read and written only once
+func (g *GitPluginDownloadCounter) SaveCounts(counts []DownloadCount) (err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
 
-	if err = g.Save(downloadData); err != nil {
-		fmt.Println(err)
+	years := map[string][]DownloadCount{}
+	for _, count := range counts {
+		year := count.Year()
+		years[year] = append(years[year], count)
 	}
-	return
-}
 
-func (g *GitPluginDownloadCounter) RecordUpdateCenterVisitData() (err error) {
-	var downloadData *PluginDownloadData
-	if downloadData, err = g.FindByYear(GetCurrentYear()); err != nil {
-		fmt.Println("cannot find by year", GetCurrentYear(), err)
-		downloadData = &PluginDownloadData{
-			Year: GetCurrentYear(),
+	for year, yearCounts := range years {
+		var downloadData *PluginDownloadData
+		if downloadData, err = g.FindByYear(year); os.IsNotExist(err) {
+			downloadData, err = &PluginDownloadData{
+				Year: year,
+			}, nil
+		} else if err != nil {
+			// do not overwrite the data which cannot be read
+			err = fmt.Errorf("cannot read the download data of %s: %v", year, err)
+			return
 		}
-	}
 
-	if err = g.UpdateCenterCountIncrease(downloadData); err != nil {
-		fmt.Println(err)
-	}
+		for _, count := range yearCounts {
+			downloadData.AddCount(count)
+		}
 
-	if err = g.Save(downloadData); err != nil {
-		fmt.Println(err)
+		if err = g.Save(downloadData); err != nil {
+			return
+		}
 	}
 	return
 }
@@ -4,6 +4,7 @@ import (
 	server "github.com/jenkins-zh/mirror-proxy/pkg"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io/ioutil"
 	"os"
 )
 
@@ -11,17 +12,19 @@ var _ = Describe("GitPluginDownloadCounter", func() {
 	var (
 		counter server.PluginDownloadCounter
 		year    string
+		dir     string
 	)
 
 	JustBeforeEach(func() {
-		counter = &server.GitPluginDownloadCounter{}
+		var err error
+		dir, err = ioutil.TempDir("", "counter")
+		Expect(err).NotTo(HaveOccurred())
+		counter = &server.GitPluginDownloadCounter{Path: dir}
 		year = server.GetCurrentYear()
 	})
 
 	AfterEach(func() {
-		gitCounter := counter.(*server.GitPluginDownloadCounter)
-		err := os.RemoveAll(gitCounter.GetDataFilePath(year))
-		Expect(err).NotTo(HaveOccurred())
+		Expect(os.RemoveAll(dir)).To(Succeed())
 	})
 
 	It("ReadData", func() {
@@ -34,6 +37,60 @@ var _ = Describe("GitPluginDownloadCounter", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("SaveCounts", func() {
+		err := counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Date: year + "-01-01", Count: 2},
+			{Plugin: "git", Date: year + "-01-01", Count: 3},
+			{Plugin: "ant", Date: year + "-01-02", Count: 1},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var data server.PluginData
+		data, err = counter.FindPluginData(year, "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data).To(Equal(map[string]int64{year + "-01-01": 5}))
+	})
+
+	It("SaveCounts does not overwrite the data which cannot be read", func() {
+		gitCounter := counter.(*server.GitPluginDownloadCounter)
+		dataFile := gitCounter.GetDataFilePath(year)
+		Expect(ioutil.WriteFile(dataFile, []byte("plugins: [broken"), 0644)).To(Succeed())
+
+		err := counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Date: year + "-01-01", Count: 2},
+		})
+		Expect(err).To(HaveOccurred())
+
+		data, err := ioutil.ReadFile(dataFile)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("plugins: [broken"))
+	})
+
+	It("SaveCounts with the provider and Jenkins version", func() {
+		err := counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Provider: "tsinghua", Version: "2.263.1", Date: year + "-01-01", Count: 2},
+			{Plugin: "git", Provider: "internal", Date: year + "-01-01", Count: 3},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var data server.PluginData
+		data, err = counter.FindPluginData(year, "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data).To(Equal(map[string]int64{year + "-01-01": 5}))
+		Expect(data.Providers).To(Equal(map[string]map[string]int64{
+			"tsinghua": {year + "-01-01": 2},
+			"internal": {year + "-01-01": 3},
+		}))
+		Expect(data.Versions).To(Equal(map[string]map[string]int64{
+			"2.263.1": {year + "-01-01": 2},
+		}))
+
+		Expect(data.FilterByProvider("")).To(Equal(server.PluginData{Data: data.Data}))
+		Expect(data.FilterByProvider("*")).To(Equal(data))
+		Expect(data.FilterByProvider("internal").Data).To(Equal(map[string]int64{year + "-01-01": 3}))
+		Expect(data.FilterByProvider("fake").Data).To(BeEmpty())
+	})
+
 	It("Save", func() {
 		data := &server.PluginDownloadData{
 			Year:    year,
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// DefaultProviderLayout is the path layout used by most of the Jenkins mirrors
+const DefaultProviderLayout = "/jenkins/{path}"
+
+// Provider represents a mirror storage provider of the Jenkins artifacts
+type Provider struct {
+	Name string
+	// URL is the base URL of the mirror, for example: https://mirrors.tuna.tsinghua.edu.cn
+	URL string
+	// Layout is the path template of the artifacts, {path} will be replaced with
+	// the path after /jenkins/, for example: plugins/git/4.4.5/git.hpi
+	Layout string
+}
+
+// GetArtifactURL returns the URL of an artifact in this provider
+func (p Provider) GetArtifactURL(artifactPath string) string {
+	layout := p.Layout
+	if layout == "" {
+		layout = DefaultProviderLayout
+	}
+	artifactPath = strings.TrimPrefix(artifactPath, "/")
+	return strings.TrimSuffix(p.URL, "/") + strings.ReplaceAll(layout, "{path}", artifactPath)
+}
+
+// ProviderRegistry holds all the providers, the key is the name of provider
+type ProviderRegistry map[string]Provider
+
+// builtinProviders are the well-known providers, we do not need to configure them
+var builtinProviders = ProviderRegistry{
+	"tsinghua": {
+		Name: "tsinghua",
+		URL:  "https://mirrors.tuna.tsinghua.edu.cn",
+	},
+}
+
+// GetProviderRegistry returns all the configured providers.
+// It includes the names of "providers" and the items of "providerMirrors" from the config file
+func GetProviderRegistry() (registry ProviderRegistry) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return getProviderRegistry(viper.GetViper())
+}
+
+func getProviderRegistry(config *viper.Viper) (registry ProviderRegistry) {
+	registry = ProviderRegistry{}
+	for _, name := range getProviders(config) {
+		if provider, ok := builtinProviders[name]; ok {
+			registry[name] = provider
+		} else {
+			registry[name] = Provider{Name: name}
+		}
+	}
+
+	for name, provider := range getProviderMirrors(config) {
+		registry[name] = provider
+	}
+	return
+}
+
+// getProviderMirrors returns the providers which are configured in "providerMirrors", the key is the name of provider
+func getProviderMirrors(config *viper.Viper) (providers map[string]Provider) {
+	providers = map[string]Provider{}
+	mirrors := map[string]Provider{}
+	if err := config.UnmarshalKey("providerMirrors", &mirrors); err == nil {
+		for name, provider := range mirrors {
+			if provider.Name == "" {
+				provider.Name = name
+			}
+			providers[provider.Name] = provider
+		}
+	}
+	return
+}
+
+// Names returns the sorted names of all providers
+func (r ProviderRegistry) Names() (names []string) {
+	names = make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// Has checks if the provider exists
+func (r ProviderRegistry) Has(name string) (ok bool) {
+	_, ok = r[name]
+	return
+}
+
+// FindMirror returns the provider which has a mirror URL
+func (r ProviderRegistry) FindMirror(name string) (provider Provider, ok bool) {
+	if provider, ok = r[name]; ok && provider.URL != "" {
+		return
+	}
+	provider, ok = builtinProviders[name]
+	return
+}
+
+// GetProvider returns the provider which serves the artifacts, fallback to the default one.
+// Another healthy provider will be returned if the health checker considers the expected one is down
+func (o *ServerOptions) GetProvider(name string) (provider Provider) {
+	registry := GetProviderRegistry()
+
+	var ok bool
+	if provider, ok = registry.FindMirror(name); !ok {
+		if provider, ok = registry.FindMirror(o.DefaultProvider); !ok {
+			provider = builtinProviders["tsinghua"]
+		}
+	}
+
+	candidates := []string{provider.Name}
+	for _, candidate := range registry.Names() {
+		if _, ok = registry.FindMirror(candidate); ok {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if healthy := o.HealthChecker.PickHealthy(ProbeKindProvider, candidates...); healthy != provider.Name {
+		logger.Warn("provider is unhealthy", zap.String("provider", provider.Name), zap.String("fallback", healthy))
+		provider, _ = registry.FindMirror(healthy)
+	}
+	return
+}
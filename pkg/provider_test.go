@@ -0,0 +1,74 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+var _ = Describe("Provider", func() {
+	It("GetArtifactURL with the default layout", func() {
+		provider := server.Provider{URL: "https://mirrors.tuna.tsinghua.edu.cn/"}
+		Expect(provider.GetArtifactURL("plugins/git/4.4.5/git.hpi")).
+			To(Equal("https://mirrors.tuna.tsinghua.edu.cn/jenkins/plugins/git/4.4.5/git.hpi"))
+	})
+
+	It("GetArtifactURL with a custom layout", func() {
+		provider := server.Provider{URL: "https://repo.example.com", Layout: "/artifactory/jenkins/{path}"}
+		Expect(provider.GetArtifactURL("/plugins/git/4.4.5/git.hpi")).
+			To(Equal("https://repo.example.com/artifactory/jenkins/plugins/git/4.4.5/git.hpi"))
+	})
+})
+
+var _ = Describe("ProviderRegistry", func() {
+	var (
+		registry server.ProviderRegistry
+	)
+
+	BeforeEach(func() {
+		viper.Set("providers", []string{"tsinghua", "fake"})
+		viper.Set("providerMirrors", map[string]interface{}{
+			"internal": map[string]interface{}{
+				"url":    "https://repo.example.com",
+				"layout": "/mirror/{path}",
+			},
+		})
+	})
+
+	JustBeforeEach(func() {
+		registry = server.GetProviderRegistry()
+	})
+
+	AfterEach(func() {
+		viper.Reset()
+	})
+
+	It("should load providers from the config", func() {
+		Expect(registry.Names()).To(Equal([]string{"fake", "internal", "tsinghua"}))
+		Expect(registry.Has("internal")).To(BeTrue())
+		Expect(registry.Has("none")).To(BeFalse())
+	})
+
+	It("FindMirror", func() {
+		provider, ok := registry.FindMirror("internal")
+		Expect(ok).To(BeTrue())
+		Expect(provider.URL).To(Equal("https://repo.example.com"))
+
+		provider, ok = registry.FindMirror("tsinghua")
+		Expect(ok).To(BeTrue())
+		Expect(provider.URL).To(Equal("https://mirrors.tuna.tsinghua.edu.cn"))
+
+		_, ok = registry.FindMirror("fake")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("GetProvider should fallback to the default provider", func() {
+		opt := &server.ServerOptions{DefaultProvider: "internal"}
+		Expect(opt.GetProvider("fake").Name).To(Equal("internal"))
+		Expect(opt.GetProvider("tsinghua").Name).To(Equal("tsinghua"))
+
+		opt.DefaultProvider = "none"
+		Expect(opt.GetProvider("none").Name).To(Equal("tsinghua"))
+	})
+})
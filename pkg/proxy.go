@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// forwardRequestHeaders are the headers which will be sent to the upstream
+var forwardRequestHeaders = []string{
+	"Accept", "Accept-Encoding", "If-None-Match", "If-Modified-Since", "User-Agent",
+}
+
+// forwardResponseHeaders are the headers which will be sent back to the client
+var forwardResponseHeaders = []string{
+	"Content-Type", "Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Cache-Control", "Expires",
+}
+
+// ProxyTo fetches the target URL and streams the response body back to the client
+func (o *ServerOptions) ProxyTo(w http.ResponseWriter, r *http.Request, targetURL string) (err error) {
+	var response *http.Response
+	if response, err = fetchUpstream(r, targetURL); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(fmt.Sprintf("cannot fetch %s: %v", targetURL, err)))
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	copyHeaders(w.Header(), response.Header, forwardResponseHeaders)
+	w.WriteHeader(response.StatusCode)
+	if r.Method != http.MethodHead {
+		_, err = io.Copy(w, response.Body)
+	}
+	return
+}
+
+// redirectOrProxy sends a redirection to the client, or streams the target if the proxy mode is enabled
+func (o *ServerOptions) redirectOrProxy(w http.ResponseWriter, r *http.Request, targetURL string) (err error) {
+	GetRequestFields(r).Target = targetURL
+	if o.ProxyMode {
+		return o.ProxyTo(w, r, targetURL)
+	}
+
+	w.Header().Set("Location", targetURL)
+	w.WriteHeader(http.StatusMovedPermanently)
+	return
+}
+
+func fetchUpstream(r *http.Request, targetURL string) (response *http.Response, err error) {
+	var request *http.Request
+	if request, err = http.NewRequest(r.Method, targetURL, nil); err != nil {
+		return
+	}
+	request = request.WithContext(r.Context())
+	copyHeaders(request.Header, r.Header, forwardRequestHeaders)
+
+	response, err = http.DefaultClient.Do(request)
+	return
+}
+
+func copyHeaders(target, source http.Header, keys []string) {
+	for _, key := range keys {
+		if val := source.Get(key); val != "" {
+			target.Set(key, val)
+		}
+	}
+}
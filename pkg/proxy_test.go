@@ -0,0 +1,82 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("ProxyTo", func() {
+	var (
+		opt      *server.ServerOptions
+		upstream *httptest.Server
+		request  *http.Request
+		recorder *httptest.ResponseRecorder
+		target   string
+		err      error
+	)
+
+	BeforeEach(func() {
+		opt = &server.ServerOptions{}
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/javascript")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Mon, 02 Nov 2020 08:00:00 GMT")
+			w.Header().Set("X-Internal", "secret")
+			_, _ = w.Write([]byte("updateCenter.post({});"))
+		}))
+		target = upstream.URL + "/update-center.json"
+
+		request, err = http.NewRequest(http.MethodGet, "/update-center.json", nil)
+		Expect(err).NotTo(HaveOccurred())
+		recorder = httptest.NewRecorder()
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+	})
+
+	JustBeforeEach(func() {
+		err = opt.ProxyTo(recorder, request, target)
+	})
+
+	It("should stream the body with the headers", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("updateCenter.post({});"))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/javascript"))
+		Expect(recorder.Header().Get("ETag")).To(Equal(`"v1"`))
+		Expect(recorder.Header().Get("Last-Modified")).To(Equal("Mon, 02 Nov 2020 08:00:00 GMT"))
+		Expect(recorder.Header().Get("X-Internal")).To(BeEmpty())
+	})
+
+	Context("with a conditional request", func() {
+		BeforeEach(func() {
+			request.Header.Set("If-None-Match", `"v1"`)
+		})
+
+		It("should pass through the not modified status", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(recorder.Code).To(Equal(http.StatusNotModified))
+			Expect(recorder.Body.Len()).To(BeZero())
+		})
+	})
+
+	Context("upstream is unreachable", func() {
+		BeforeEach(func() {
+			target = "http://127.0.0.1:0/update-center.json"
+		})
+
+		It("should return bad gateway", func() {
+			Expect(err).To(HaveOccurred())
+			Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+		})
+	})
+})
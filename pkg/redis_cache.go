@@ -0,0 +1,364 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CacheTypeFile stores the cache in a YAML file
+	CacheTypeFile = "file"
+	// CacheTypeRedis stores the cache in a Redis server, it could be shared by the replicas
+	CacheTypeRedis = "redis"
+)
+
+// DefaultRedisPoolSize is the max count of the connections to the Redis server
+const DefaultRedisPoolSize = 10
+
+// RedisCacheServer stores the cache items in a Redis server, each item is a JSON string with the key prefix.
+// The connections are kept in a pool, so the concurrent commands do not wait for each other
+type RedisCacheServer struct {
+	Address   string
+	Password  string
+	DB        int
+	KeyPrefix string
+	// TTL is the time to live of the saved items, they never expire if it's zero
+	TTL time.Duration
+	// Timeout is the timeout of connecting, waiting for a connection of the pool and each command
+	Timeout time.Duration
+	// PoolSize is the max count of the connections, DefaultRedisPoolSize is used if it's zero
+	PoolSize int
+
+	lock sync.Mutex
+	idle []*redisConn
+	// slots limits the count of the connections in use
+	slots chan struct{}
+}
+
+// redisConn is a connection to the Redis server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisCacheItem is the format of a cache item in Redis
+type redisCacheItem struct {
+	Value     string    `json:"value"`
+	FetchTime time.Time `json:"fetchTime"`
+	TTL       string    `json:"ttl,omitempty"`
+	Negative  bool      `json:"negative,omitempty"`
+}
+
+// Load returns the value of a key, it's empty if the item does not exist, expired or negative
+func (c *RedisCacheServer) Load(key string) (val string) {
+	if item, ok := c.LoadItem(key); ok && !item.Negative && !item.Expired() {
+		val = item.Value
+	}
+	return
+}
+
+// Save saves the value with the TTL of the cache
+func (c *RedisCacheServer) Save(key string, val string) error {
+	return c.SaveItem(key, CacheItem{Value: val, FetchTime: time.Now(), TTL: c.TTL})
+}
+
+// LoadItem returns the item from Redis
+func (c *RedisCacheServer) LoadItem(key string) (item CacheItem, ok bool) {
+	reply, err := c.do("GET", c.KeyPrefix+key)
+	if data, isString := reply.(string); err == nil && isString {
+		item, err = decodeRedisCacheItem(data)
+		ok = err == nil
+	}
+	return
+}
+
+// SaveItem saves the item into Redis, the negative one expires in Redis with its TTL
+func (c *RedisCacheServer) SaveItem(key string, item CacheItem) (err error) {
+	value := redisCacheItem{Value: item.Value, FetchTime: item.FetchTime, Negative: item.Negative}
+	if item.TTL > 0 {
+		value.TTL = item.TTL.String()
+	}
+
+	var data []byte
+	if data, err = json.Marshal(value); err != nil {
+		return
+	}
+
+	args := []string{"SET", c.KeyPrefix + key, string(data)}
+	// the items are removed by Redis once they expire, instead of being kept forever
+	if item.TTL > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(item.TTL/time.Millisecond), 10))
+	}
+	_, err = c.do(args...)
+	return
+}
+
+// List returns all the items in Redis, the negative ones are not included
+func (c *RedisCacheServer) List() (items map[string]string, err error) {
+	var keys []string
+	if keys, err = c.scan(); err != nil {
+		return
+	}
+
+	items = map[string]string{}
+	for _, key := range keys {
+		if item, ok := c.LoadItem(key); ok && !item.Negative {
+			items[key] = item.Value
+		}
+	}
+	return
+}
+
+// Clear removes the given keys, or all the items with the key prefix if there is no key
+func (c *RedisCacheServer) Clear(keys ...string) (err error) {
+	if len(keys) == 0 {
+		if keys, err = c.scan(); err != nil || len(keys) == 0 {
+			return
+		}
+	}
+
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, c.KeyPrefix+key)
+	}
+	_, err = c.do(args...)
+	return
+}
+
+// Close closes the idle connections
+func (c *RedisCacheServer) Close() (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, conn := range c.idle {
+		if closeErr := conn.conn.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	c.idle = nil
+	return
+}
+
+// scan returns all the keys with the prefix, the prefix is trimmed
+func (c *RedisCacheServer) scan() (keys []string, err error) {
+	cursor := "0"
+	for {
+		var reply interface{}
+		if reply, err = c.do("SCAN", cursor, "MATCH", escapeRedisPattern(c.KeyPrefix)+"*", "COUNT", "100"); err != nil {
+			return
+		}
+
+		result, ok := reply.([]interface{})
+		if !ok || len(result) != 2 {
+			err = fmt.Errorf("unexpected reply of SCAN: %v", reply)
+			return
+		}
+		page, _ := result[1].([]interface{})
+		for _, key := range page {
+			if name, isString := key.(string); isString {
+				keys = append(keys, strings.TrimPrefix(name, c.KeyPrefix))
+			}
+		}
+
+		if cursor, _ = result[0].(string); cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// do sends a command with a connection of the pool then reads the reply
+func (c *RedisCacheServer) do(args ...string) (reply interface{}, err error) {
+	var conn *redisConn
+	if conn, err = c.get(); err != nil {
+		return
+	}
+
+	reply, err = conn.command(c.timeout(), args...)
+	_, isRedisErr := err.(redisError)
+	c.put(conn, err == nil || isRedisErr)
+	return
+}
+
+// get takes an idle connection or creates a new one, it waits if all the connections of the pool are in use
+func (c *RedisCacheServer) get() (conn *redisConn, err error) {
+	c.lock.Lock()
+	if c.slots == nil {
+		c.slots = make(chan struct{}, c.poolSize())
+	}
+	slots := c.slots
+	c.lock.Unlock()
+
+	timer := time.NewTimer(c.timeout())
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+	case <-timer.C:
+		err = fmt.Errorf("timeout waiting for a connection of the Redis server %s", c.Address)
+		return
+	}
+
+	c.lock.Lock()
+	if count := len(c.idle); count > 0 {
+		conn = c.idle[count-1]
+		c.idle = c.idle[:count-1]
+	}
+	c.lock.Unlock()
+
+	if conn == nil {
+		if conn, err = c.connect(); err != nil {
+			<-slots
+		}
+	}
+	return
+}
+
+// put gives back the connection, the broken one is closed with the idle ones which might be broken too
+func (c *RedisCacheServer) put(conn *redisConn, reusable bool) {
+	c.lock.Lock()
+	if reusable {
+		c.idle = append(c.idle, conn)
+	} else {
+		_ = conn.conn.Close()
+		for _, idle := range c.idle {
+			_ = idle.conn.Close()
+		}
+		c.idle = nil
+	}
+	slots := c.slots
+	c.lock.Unlock()
+	<-slots
+}
+
+func (c *RedisCacheServer) connect() (conn *redisConn, err error) {
+	timeout := c.timeout()
+	var netConn net.Conn
+	if netConn, err = net.DialTimeout("tcp", c.Address, timeout); err != nil {
+		return
+	}
+	conn = &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if c.Password != "" {
+		_, err = conn.command(timeout, "AUTH", c.Password)
+	}
+	if err == nil && c.DB != 0 {
+		_, err = conn.command(timeout, "SELECT", strconv.Itoa(c.DB))
+	}
+	if err != nil {
+		_ = netConn.Close()
+		conn = nil
+	}
+	return
+}
+
+func (c *redisConn) command(timeout time.Duration, args ...string) (reply interface{}, err error) {
+	if err = c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	if _, err = c.conn.Write(encodeRedisCommand(args...)); err != nil {
+		return
+	}
+	return readRedisReply(c.reader)
+}
+
+func (c *RedisCacheServer) poolSize() int {
+	if c.PoolSize > 0 {
+		return c.PoolSize
+	}
+	return DefaultRedisPoolSize
+}
+
+func (c *RedisCacheServer) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+func decodeRedisCacheItem(data string) (item CacheItem, err error) {
+	var value redisCacheItem
+	if err = json.Unmarshal([]byte(data), &value); err != nil {
+		return
+	}
+
+	item = CacheItem{Value: value.Value, FetchTime: value.FetchTime, Negative: value.Negative}
+	if value.TTL != "" {
+		item.TTL, err = time.ParseDuration(value.TTL)
+	}
+	return
+}
+
+// redisError is the error reply of Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// encodeRedisCommand encodes the command as an array of the bulk strings
+func encodeRedisCommand(args ...string) []byte {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		builder.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+	return []byte(builder.String())
+}
+
+// readRedisReply reads a reply, the bulk and simple strings are string, the integers are int64,
+// the arrays are []interface{}, the nil bulk string or array is nil
+func readRedisReply(reader *bufio.Reader) (reply interface{}, err error) {
+	var line string
+	if line, err = reader.ReadString('\n'); err != nil {
+		return
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		err = fmt.Errorf("empty reply of Redis")
+		return
+	}
+
+	switch line[0] {
+	case '+':
+		reply = line[1:]
+	case '-':
+		err = redisError(line[1:])
+	case ':':
+		reply, err = strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		var size int
+		if size, err = strconv.Atoi(line[1:]); err != nil || size < 0 {
+			return
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err == nil {
+			reply = string(data[:size])
+		}
+	case '*':
+		var size int
+		if size, err = strconv.Atoi(line[1:]); err != nil || size < 0 {
+			return
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], err = readRedisReply(reader); err != nil {
+				return
+			}
+		}
+		reply = items
+	default:
+		err = fmt.Errorf("unknown reply of Redis: %s", line)
+	}
+	return
+}
+
+// escapeRedisPattern escapes the special characters of the glob-style pattern
+func escapeRedisPattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(value)
+}
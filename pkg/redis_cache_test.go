@@ -0,0 +1,289 @@
+package pkg_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeRedisServer is an in-process server which supports a few commands of Redis
+type fakeRedisServer struct {
+	listener net.Listener
+	password string
+
+	lock   sync.Mutex
+	data   map[int]map[string]string
+	expiry map[string]string
+	conns  []net.Conn
+}
+
+func newFakeRedisServer(password string) *fakeRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	fake := &fakeRedisServer{
+		listener: listener,
+		password: password,
+		data:     map[int]map[string]string{},
+		expiry:   map[string]string{},
+	}
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			fake.lock.Lock()
+			fake.conns = append(fake.conns, conn)
+			fake.lock.Unlock()
+			go fake.serve(conn)
+		}
+	}()
+	return fake
+}
+
+func (f *fakeRedisServer) Address() string {
+	return f.listener.Addr().String()
+}
+
+// CloseConnections closes all the client connections, the clients need to connect again
+func (f *fakeRedisServer) CloseConnections() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedisServer) Close() {
+	_ = f.listener.Close()
+	f.CloseConnections()
+}
+
+func (f *fakeRedisServer) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	db := 0
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "AUTH":
+			if authenticated = args[1] == f.password; authenticated {
+				reply = "+OK\r\n"
+			} else {
+				reply = "-ERR invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case command == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			reply = "+OK\r\n"
+		default:
+			reply = f.execute(db, args)
+		}
+
+		if _, err = io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedisServer) execute(db int, args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.data[db] == nil {
+		f.data[db] = map[string]string{}
+	}
+	data := f.data[db]
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if value, ok := data[args[1]]; ok {
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+		}
+		return "$-1\r\n"
+	case "SET":
+		data[args[1]] = args[2]
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			f.expiry[args[1]] = args[4]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := data[key]; ok {
+				delete(data, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "SCAN":
+		// it returns two keys in a page to make sure the client follows the cursor
+		var keys []string
+		for key := range data {
+			if matched, _ := filepath.Match(args[3], key); matched {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		cursor, _ := strconv.Atoi(args[1])
+		next := cursor + 2
+		if next >= len(keys) {
+			next = 0
+		}
+		page := keys[cursor:]
+		if len(page) > 2 {
+			page = page[:2]
+		}
+
+		var buf bytes.Buffer
+		buf.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%d\r\n*%d\r\n", len(strconv.Itoa(next)), next, len(page)))
+		for _, key := range page {
+			buf.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(key), key))
+		}
+		return buf.String()
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) (args []string, err error) {
+	var line string
+	if line, err = reader.ReadString('\n'); err != nil {
+		return
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line)[1:])
+	for i := 0; i < count; i++ {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line)[1:])
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return
+		}
+		args = append(args, string(data[:size]))
+	}
+	return
+}
+
+var _ = Describe("RedisCacheServer", func() {
+	var (
+		fake    *fakeRedisServer
+		replica *server.RedisCacheServer
+		another *server.RedisCacheServer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeRedisServer("secret")
+		replica = &server.RedisCacheServer{Address: fake.Address(), Password: "secret", KeyPrefix: "mirror-proxy:cache:", TTL: time.Hour}
+		another = &server.RedisCacheServer{Address: fake.Address(), Password: "secret", KeyPrefix: "mirror-proxy:cache:"}
+	})
+
+	AfterEach(func() {
+		Expect(replica.Close()).To(Succeed())
+		Expect(another.Close()).To(Succeed())
+		fake.Close()
+	})
+
+	It("share the items between the replicas", func() {
+		Expect(replica.Save("", "https://a.com/update-center.json")).To(Succeed())
+		Expect(replica.Save("2.263.1", "https://b.com/update-center.json")).To(Succeed())
+		Expect(replica.Save("2.264", "https://c.com/update-center.json")).To(Succeed())
+
+		Expect(another.Load("2.263.1")).To(Equal("https://b.com/update-center.json"))
+		item, ok := another.LoadItem("2.263.1")
+		Expect(ok).To(BeTrue())
+		Expect(item.TTL).To(Equal(time.Hour))
+		Expect(item.Expired()).To(BeFalse())
+
+		Expect(another.List()).To(Equal(map[string]string{
+			"":        "https://a.com/update-center.json",
+			"2.263.1": "https://b.com/update-center.json",
+			"2.264":   "https://c.com/update-center.json",
+		}))
+
+		Expect(another.Clear("2.264")).To(Succeed())
+		Expect(replica.Load("2.264")).To(BeEmpty())
+		Expect(another.Clear()).To(Succeed())
+		Expect(replica.List()).To(BeEmpty())
+	})
+
+	It("negative item expires in Redis", func() {
+		Expect(replica.SaveItem("2.265", server.CacheItem{Value: "not found", FetchTime: time.Now(), TTL: time.Minute, Negative: true})).To(Succeed())
+		Expect(fake.expiry).To(HaveKeyWithValue("mirror-proxy:cache:2.265", "60000"))
+		Expect(replica.Load("2.265")).To(BeEmpty())
+		Expect(replica.List()).To(BeEmpty())
+	})
+
+	It("item expires in Redis", func() {
+		Expect(replica.Save("2.263.1", "url")).To(Succeed())
+		Expect(fake.expiry).To(HaveKeyWithValue("mirror-proxy:cache:2.263.1", "3600000"))
+
+		Expect(another.Save("2.264", "url")).To(Succeed())
+		Expect(fake.expiry).NotTo(HaveKey("mirror-proxy:cache:2.264"))
+	})
+
+	It("select the database", func() {
+		another.DB = 1
+		Expect(replica.Save("2.263.1", "url")).To(Succeed())
+		Expect(another.Load("2.263.1")).To(BeEmpty())
+	})
+
+	It("wrong password", func() {
+		another.Password = "wrong"
+		Expect(another.Save("2.263.1", "url")).To(MatchError("ERR invalid password"))
+		Expect(another.Load("2.263.1")).To(BeEmpty())
+	})
+
+	It("share the pool between the concurrent commands", func() {
+		replica.PoolSize = 3
+		var wait sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wait.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wait.Done()
+				version := fmt.Sprintf("2.%d", i)
+				Expect(replica.Save(version, "url-"+version)).To(Succeed())
+				Expect(replica.Load(version)).To(Equal("url-" + version))
+			}(i)
+		}
+		wait.Wait()
+
+		Expect(another.List()).To(HaveLen(30))
+		fake.lock.Lock()
+		defer fake.lock.Unlock()
+		// the other one is used by another
+		Expect(len(fake.conns)).To(BeNumerically("<=", 4))
+	})
+
+	It("connect again when the connection is closed", func() {
+		Expect(replica.Save("2.263.1", "url")).To(Succeed())
+		fake.CloseConnections()
+
+		// the first command fails with the closed connection
+		_ = replica.Save("2.264", "url")
+		Expect(replica.Save("2.264", "url")).To(Succeed())
+		Expect(replica.Load("2.263.1")).To(Equal("url"))
+	})
+})
@@ -0,0 +1,224 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// reloadableFlags are the flags which take effect without restarting the server
+var reloadableFlags = []string{"default-provider", "default-json-server", "cert", "key"}
+
+// CertificateStore holds the TLS certificate of the server, it could be replaced at runtime
+type CertificateStore struct {
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// Load reads the certificate from the files, the current one is kept if it fails
+func (s *CertificateStore) Load(certFile, keyFile string) (err error) {
+	var certificate tls.Certificate
+	if certificate, err = tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		s.set(&certificate)
+	}
+	return
+}
+
+func (s *CertificateStore) set(certificate *tls.Certificate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.certificate = certificate
+}
+
+// GetCertificate returns the current certificate, it works as tls.Config.GetCertificate
+func (s *CertificateStore) GetCertificate(*tls.ClientHelloInfo) (certificate *tls.Certificate, err error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if certificate = s.certificate; certificate == nil {
+		err = fmt.Errorf("no certificate is loaded")
+	}
+	return
+}
+
+// snapshot copies the options, the reloadable fields might be changed by another goroutine
+func (o *ServerOptions) snapshot() ServerOptions {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return *o
+}
+
+// ReloadConfig reads the config file again, then applies the providers, the JSON servers, the default provider,
+// the default JSON server and the TLS certificate. The current config is kept if the new one is invalid
+func (o *ServerOptions) ReloadConfig(flags *pflag.FlagSet) (err error) {
+	current := o.snapshot()
+	configLock.RLock()
+	file := viper.ConfigFileUsed()
+	configLock.RUnlock()
+	if file == "" {
+		return fmt.Errorf("no config file is used")
+	}
+
+	var data []byte
+	if data, err = ioutil.ReadFile(file); err != nil {
+		return
+	}
+
+	config := viper.New()
+	config.SetConfigFile(file)
+	if err = config.ReadConfig(bytes.NewReader(data)); err != nil {
+		return
+	}
+	if problems := validateConfig(config, flags); len(problems) > 0 {
+		return fmt.Errorf("invalid config: %v", problems)
+	}
+
+	values := map[string]string{
+		"default-provider":    current.DefaultProvider,
+		"default-json-server": current.DefaultJSONServer,
+		"cert":                current.CertFile,
+		"key":                 current.KeyFile,
+	}
+	for _, name := range reloadableFlags {
+		values[name] = reloadValue(config, flags, name, values[name])
+	}
+	if err = validateDefaultProvider(config, values["default-provider"]); err != nil {
+		return
+	}
+
+	var certificate *tls.Certificate
+	// the certificate files might be renewed in place, so they are always loaded again
+	if o.Certificate != nil && values["cert"] != "" {
+		var pair tls.Certificate
+		if pair, err = tls.LoadX509KeyPair(values["cert"], values["key"]); err != nil {
+			return
+		}
+		certificate = &pair
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+	if err = viper.ReadConfig(bytes.NewReader(data)); err != nil {
+		return
+	}
+	o.DefaultProvider, o.DefaultJSONServer = values["default-provider"], values["default-json-server"]
+	o.CertFile, o.KeyFile = values["cert"], values["key"]
+	if certificate != nil {
+		o.Certificate.set(certificate)
+	}
+	return
+}
+
+// reloadValue returns the value of a flag from the new config, the flags and env variables have the higher precedence
+func reloadValue(config *viper.Viper, flags *pflag.FlagSet, name, current string) string {
+	flag := flags.Lookup(name)
+	if flag != nil && flag.Changed {
+		return current
+	}
+	envName := EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if _, ok := os.LookupEnv(envName); ok {
+		return current
+	}
+
+	for _, key := range flagConfigKeys(name) {
+		if config.IsSet(key) {
+			return config.GetString(key)
+		}
+	}
+	if flag != nil {
+		return flag.DefValue
+	}
+	return current
+}
+
+// WatchConfig reloads the config when the config file is changed or the server gets SIGHUP,
+// it stops watching when the done channel is closed
+func (o *ServerOptions) WatchConfig(flags *pflag.FlagSet, done <-chan struct{}) {
+	reload := func(reason string) {
+		if err := o.ReloadConfig(flags); err != nil {
+			logger.Error("cannot reload the config, keep the current one", zap.String("reason", reason), zap.Error(err))
+		} else {
+			logger.Info("the config is reloaded", zap.String("reason", reason))
+		}
+	}
+
+	configLock.RLock()
+	file := viper.ConfigFileUsed()
+	configLock.RUnlock()
+	if file != "" {
+		if err := watchConfigFile(file, done, func() {
+			reload("file changed")
+		}); err != nil {
+			logger.Error("cannot watch the config file", zap.String("file", file), zap.Error(err))
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				reload("SIGHUP")
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// watchConfigFile calls changed when the file is written or replaced until the done channel is closed.
+// The directory is watched, so the file replaced by an editor or the symlink of a Kubernetes ConfigMap works too
+func watchConfigFile(file string, done <-chan struct{}, changed func()) (err error) {
+	var watcher *fsnotify.Watcher
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		return
+	}
+
+	configFile := filepath.Clean(file)
+	if err = watcher.Add(filepath.Dir(configFile)); err != nil {
+		_ = watcher.Close()
+		return
+	}
+	realFile, _ := filepath.EvalSymlinks(configFile)
+
+	go func() {
+		defer func() {
+			_ = watcher.Close()
+		}()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				currentFile, _ := filepath.EvalSymlinks(configFile)
+				if filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0 ||
+					currentFile != "" && currentFile != realFile {
+					realFile = currentFile
+					changed()
+				}
+			case watchErr, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("failed to watch the config file", zap.Error(watchErr))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return
+}
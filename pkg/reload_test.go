@@ -0,0 +1,173 @@
+package pkg_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("ReloadConfig", func() {
+	var (
+		dir        string
+		configFile string
+		opt        *server.ServerOptions
+		flags      *pflag.FlagSet
+		err        error
+	)
+
+	writeConfig := func(config string) {
+		Expect(ioutil.WriteFile(configFile, []byte(config), 0644)).To(Succeed())
+	}
+
+	writeCertificate := func(commonName string) {
+		key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(keyErr).NotTo(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: commonName},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, certErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(certErr).NotTo(HaveOccurred())
+		keyDer, keyErr := x509.MarshalECPrivateKey(key)
+		Expect(keyErr).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "server.crt"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "server.key"),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)).To(Succeed())
+	}
+
+	getCommonName := func(store *server.CertificateStore) string {
+		certificate, certErr := store.GetCertificate(nil)
+		Expect(certErr).NotTo(HaveOccurred())
+		leaf, parseErr := x509.ParseCertificate(certificate.Certificate[0])
+		Expect(parseErr).NotTo(HaveOccurred())
+		return leaf.Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "reload")
+		Expect(err).To(BeNil())
+		configFile = filepath.Join(dir, "mirror-proxy.yaml")
+		writeConfig(`providers:
+  - tsinghua
+jsonServers:
+  gitee: https://jenkins-zh.gitee.io/update-center-mirror
+`)
+		viper.SetConfigFile(configFile)
+		Expect(viper.ReadInConfig()).To(Succeed())
+
+		opt = &server.ServerOptions{DefaultProvider: "tsinghua", DefaultJSONServer: "gitee"}
+		flags = pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringVar(&opt.DefaultProvider, "default-provider", "tsinghua", "")
+		flags.StringVar(&opt.CertFile, "cert", "", "")
+		flags.StringVar(&opt.KeyFile, "key", "", "")
+	})
+
+	AfterEach(func() {
+		viper.Reset()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("apply the new config", func() {
+		writeConfig(`providers:
+  - tsinghua
+  - huawei
+jsonServers:
+  gitee: https://jenkins-zh.gitee.io/update-center-mirror
+  github: https://jenkins-zh.github.io/update-center-mirror
+default-provider: huawei
+defaultJSONServer: github
+`)
+		Expect(opt.ReloadConfig(flags)).To(Succeed())
+		Expect(server.GetProviders()).To(Equal([]string{"tsinghua", "huawei"}))
+		Expect(server.GetJSONServers()).To(HaveKey("github"))
+		Expect(opt.DefaultProvider).To(Equal("huawei"))
+		Expect(opt.GetDefaultJSONServer()).To(Equal("https://jenkins-zh.github.io/update-center-mirror"))
+	})
+
+	It("fallback to the default value when the key is removed", func() {
+		opt.DefaultProvider = "huawei"
+		Expect(opt.ReloadConfig(flags)).To(Succeed())
+		Expect(opt.DefaultProvider).To(Equal("tsinghua"))
+	})
+
+	It("keep the old config when the new one is invalid", func() {
+		writeConfig(`jsonServers:
+  gitee: not a url
+default-provider: huawei
+`)
+		Expect(opt.ReloadConfig(flags)).To(MatchError(ContainSubstring(`the URL of JSON server gitee is invalid`)))
+		Expect(server.GetJSONServers()).To(Equal(map[string]string{"gitee": "https://jenkins-zh.gitee.io/update-center-mirror"}))
+		Expect(opt.DefaultProvider).To(Equal("tsinghua"))
+	})
+
+	It("keep the old config when the default provider is not in the providers", func() {
+		writeConfig(`providers:
+  - huawei
+default-provider: aliyun
+`)
+		Expect(opt.ReloadConfig(flags)).To(MatchError(ContainSubstring(`the default provider aliyun is not in the providers`)))
+		Expect(server.GetProviders()).To(Equal([]string{"tsinghua"}))
+		Expect(opt.DefaultProvider).To(Equal("tsinghua"))
+	})
+
+	It("reload when the file is changed until it's done", func() {
+		done := make(chan struct{})
+		opt.WatchConfig(flags, done)
+
+		writeConfig(`providers:
+  - tsinghua
+  - huawei
+`)
+		Eventually(server.GetProviders).Should(Equal([]string{"tsinghua", "huawei"}))
+
+		close(done)
+		// wait for the watcher to stop
+		time.Sleep(100 * time.Millisecond)
+		writeConfig(`providers:
+  - huawei
+`)
+		Consistently(server.GetProviders, 300*time.Millisecond).Should(Equal([]string{"tsinghua", "huawei"}))
+	})
+
+	It("the flag has the higher precedence", func() {
+		Expect(flags.Set("default-provider", "aliyun")).To(Succeed())
+		writeConfig("default-provider: huawei\n")
+		Expect(opt.ReloadConfig(flags)).To(Succeed())
+		Expect(opt.DefaultProvider).To(Equal("aliyun"))
+	})
+
+	It("reload the certificate", func() {
+		writeCertificate("old")
+		opt.CertFile, opt.KeyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		opt.Certificate = &server.CertificateStore{}
+		Expect(opt.Certificate.Load(opt.CertFile, opt.KeyFile)).To(Succeed())
+		Expect(getCommonName(opt.Certificate)).To(Equal("old"))
+
+		writeCertificate("new")
+		writeConfig("cert: " + opt.CertFile + "\nkey: " + opt.KeyFile + "\n")
+		Expect(opt.ReloadConfig(flags)).To(Succeed())
+		Expect(getCommonName(opt.Certificate)).To(Equal("new"))
+
+		By("keep the old certificate when the new one is broken")
+		Expect(ioutil.WriteFile(opt.KeyFile, []byte("broken"), 0600)).To(Succeed())
+		Expect(opt.ReloadConfig(flags)).NotTo(Succeed())
+		Expect(getCommonName(opt.Certificate)).To(Equal("new"))
+	})
+})
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultRewriteCacheEntries is the max count of the cached rewritten update centers
+const DefaultRewriteCacheEntries = 16
+
+// RewrittenUpdateCenters caches the rewritten update centers in memory. They are fetched again after the TTL,
+// the unchanged ones are kept by the ETag and Last-Modified of the provider
+type RewrittenUpdateCenters struct {
+	TTL time.Duration
+	// MaxEntries is the max count of the cached update centers, the oldest one is removed when it's exceeded
+	MaxEntries int
+	// Client fetches the update centers, a client with DefaultFetchTimeout is used if it's nil
+	Client *http.Client
+
+	lock    sync.Mutex
+	entries map[string]*rewrittenUpdateCenter
+	lookups lookupGroup
+}
+
+type rewrittenUpdateCenter struct {
+	data         []byte
+	etag         string
+	lastModified string
+	fetchTime    time.Time
+}
+
+// NewRewrittenUpdateCenters creates the cache of the rewritten update centers
+func NewRewrittenUpdateCenters(ttl time.Duration) *RewrittenUpdateCenters {
+	return &RewrittenUpdateCenters{
+		TTL:        ttl,
+		MaxEntries: DefaultRewriteCacheEntries,
+	}
+}
+
+// Get returns the rewritten update center of the source URL. The transform function is only called
+// when the update center is changed, the stale one is returned if the source is unreachable.
+// The update center is fetched for every call if the cache is nil
+func (c *RewrittenUpdateCenters) Get(sourceURL, key string, transform func([]byte) ([]byte, error)) (data []byte, err error) {
+	if c == nil {
+		if data, err = FetchUpdateCenterData(sourceURL); err == nil {
+			data, err = transform(data)
+		}
+		return
+	}
+
+	c.lock.Lock()
+	entry := c.entries[key]
+	c.lock.Unlock()
+	if entry != nil && time.Since(entry.fetchTime) < c.TTL {
+		return entry.data, nil
+	}
+
+	var value interface{}
+	if value, err, _ = c.lookups.Do(key, func() (interface{}, error) {
+		return c.refresh(sourceURL, key, entry, transform)
+	}); err == nil {
+		data = value.(*rewrittenUpdateCenter).data
+	} else if entry != nil {
+		logger.Warn("cannot refresh the update center, use the stale one", zap.String("url", sourceURL), zap.Error(err))
+		data, err = entry.data, nil
+	}
+	return
+}
+
+func (c *RewrittenUpdateCenters) refresh(sourceURL, key string, entry *rewrittenUpdateCenter,
+	transform func([]byte) ([]byte, error)) (updated *rewrittenUpdateCenter, err error) {
+	var request *http.Request
+	if request, err = http.NewRequest(http.MethodGet, sourceURL, nil); err != nil {
+		return
+	}
+	if entry != nil {
+		if entry.etag != "" {
+			request.Header.Set("If-None-Match", entry.etag)
+		}
+		if entry.lastModified != "" {
+			request.Header.Set("If-Modified-Since", entry.lastModified)
+		}
+	}
+
+	client := c.Client
+	if client == nil {
+		client = fetchClient
+	}
+	var response *http.Response
+	if response, err = client.Do(request); err != nil {
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	updated = &rewrittenUpdateCenter{
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+		fetchTime:    time.Now(),
+	}
+	switch {
+	case entry != nil && (response.StatusCode == http.StatusNotModified ||
+		response.StatusCode == http.StatusOK && updated.etag != "" && updated.etag == entry.etag):
+		updated.data = entry.data
+		if updated.etag == "" {
+			updated.etag, updated.lastModified = entry.etag, entry.lastModified
+		}
+	case response.StatusCode == http.StatusOK:
+		var data []byte
+		if data, err = ioutil.ReadAll(response.Body); err != nil {
+			return
+		}
+		if updated.data, err = transform(TrimUpdateCenterJSONP(data)); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("cannot fetch %s, status code: %d", sourceURL, response.StatusCode)
+		return
+	}
+
+	c.store(key, updated)
+	return
+}
+
+func (c *RewrittenUpdateCenters) store(key string, entry *rewrittenUpdateCenter) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]*rewrittenUpdateCenter{}
+	}
+	c.entries[key] = entry
+
+	for c.MaxEntries > 0 && len(c.entries) > c.MaxEntries {
+		oldest := ""
+		for name, item := range c.entries {
+			if name != key && (oldest == "" || item.fetchTime.Before(c.entries[oldest].fetchTime)) {
+				oldest = name
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
@@ -0,0 +1,105 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("RewrittenUpdateCenters", func() {
+	var (
+		upstream   *httptest.Server
+		hits       int32
+		etag       atomic.Value
+		down       int32
+		transforms int32
+		cache      *server.RewrittenUpdateCenters
+	)
+
+	transform := func(data []byte) ([]byte, error) {
+		atomic.AddInt32(&transforms, 1)
+		return []byte(strings.ToUpper(string(data))), nil
+	}
+
+	BeforeEach(func() {
+		hits, down, transforms = 0, 0, 0
+		etag.Store(`"v1"`)
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			if atomic.LoadInt32(&down) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			current := etag.Load().(string)
+			w.Header().Set("ETag", current)
+			if r.Header.Get("If-None-Match") == current {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write([]byte(`updateCenter.post({"version":` + current + `});`))
+		}))
+		cache = server.NewRewrittenUpdateCenters(time.Hour)
+	})
+
+	AfterEach(func() {
+		upstream.Close()
+	})
+
+	get := func(key string) string {
+		data, err := cache.Get(upstream.URL+"/"+key, key, transform)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("fetch and rewrite once before it expires", func() {
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+		Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+		Expect(atomic.LoadInt32(&transforms)).To(Equal(int32(1)))
+	})
+
+	It("rewrite again only when the ETag is changed", func() {
+		cache.TTL = 0
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+		Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+		Expect(atomic.LoadInt32(&transforms)).To(Equal(int32(1)))
+
+		etag.Store(`"v2"`)
+		Expect(get("a")).To(Equal(`{"VERSION":"V2"}`))
+		Expect(atomic.LoadInt32(&transforms)).To(Equal(int32(2)))
+	})
+
+	It("use the stale one when the source is down", func() {
+		cache.TTL = 0
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+		atomic.StoreInt32(&down, 1)
+		Expect(get("a")).To(Equal(`{"VERSION":"V1"}`))
+
+		_, err := cache.Get(upstream.URL+"/b", "b", transform)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("remove the oldest one when there are too many", func() {
+		cache.MaxEntries = 1
+		get("a")
+		get("b")
+		get("b")
+		Expect(atomic.LoadInt32(&hits)).To(Equal(int32(2)))
+		get("a")
+		Expect(atomic.LoadInt32(&hits)).To(Equal(int32(3)))
+	})
+
+	It("fetch for every call without the cache", func() {
+		var nilCache *server.RewrittenUpdateCenters
+		data, err := nilCache.Get(upstream.URL, "a", transform)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`{"VERSION":"V1"}`))
+	})
+})
@@ -5,18 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/jenkins-zh/mirror-proxy/pkg/helper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-// AddContext add context inject all handlers
+// AddContext add context inject all handlers, the requests will be logged
 func AddContext(next http.Handler, option *ServerOptions) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), context.TODO(), *option)
+	return logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := option.snapshot()
+		if o.Printer == nil {
+			requestLogger := logger.With(zap.String("request_id", GetRequestFields(r).RequestID))
+			o.Printer = NewLogPrinter(requestLogger, zapcore.ErrorLevel)
+		}
+
+		ctx := context.WithValue(r.Context(), context.TODO(), o)
 		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	}))
 }
 
 // GetUpdateCenterQuery returns the query object
@@ -45,17 +55,15 @@ func GetUpdateCenterQuery(querySources ...QuerySource) (query UpdateCenterQuery)
 func HandleToolsUpdate(w http.ResponseWriter, r *http.Request) {
 	o := r.Context().Value(context.TODO()).(ServerOptions)
 	query := GetUpdateCenterQuery(r.URL.Query(), r.Header)
-	fmt.Println(r.RequestURI)
 
 	var err error
 	var targetURL *url.URL
 	if targetURL, err = o.GetAndCacheURL(query); err == nil {
-		providerURL := o.GetProviderURL(targetURL, query)
+		providerURL := o.getRedirectURL(r, targetURL, query)
 
 		providerURL = strings.ReplaceAll(providerURL, "update-center.json", r.RequestURI)
 
-		w.Header().Set("Location", providerURL)
-		w.WriteHeader(http.StatusMovedPermanently)
+		err = o.redirectOrProxy(w, r, providerURL)
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 
@@ -69,22 +77,21 @@ func HandleUpdateCenter(w http.ResponseWriter, r *http.Request) {
 	o := r.Context().Value(context.TODO()).(ServerOptions)
 	query := GetUpdateCenterQuery(r.URL.Query(), r.Header)
 
-	o.WorkPool.AddTask(Task{
-		TaskFunc: func(_ interface{}) {
-			pluginDownloadCounter := &GitPluginDownloadCounter{
-				Path: o.DataFilePath,
-			}
-			if err := pluginDownloadCounter.RecordUpdateCenterVisitData(); err != nil {
-				fmt.Println(err)
-			}
-		},
+	// the provider is resolved, the clients cannot create the unknown ones
+	o.recordDownload(DownloadCount{
+		Plugin:   "update-center",
+		Provider: o.resolveProvider(query.Provider),
+		Version:  query.Version,
 	})
 
 	var err error
 	var targetURL *url.URL
 	if targetURL, err = o.GetAndCacheURL(query); err == nil {
-		w.Header().Set("Location", o.GetProviderURL(targetURL, query))
-		w.WriteHeader(http.StatusMovedPermanently)
+		if o.RewriteUpdateCenter {
+			err = o.serveRewrittenUpdateCenter(w, o.getRedirectURL(r, targetURL, query), query)
+		} else {
+			err = o.redirectOrProxy(w, r, o.getRedirectURL(r, targetURL, query))
+		}
 	} else {
 		w.WriteHeader(http.StatusNotFound)
 
@@ -93,23 +100,50 @@ func HandleUpdateCenter(w http.ResponseWriter, r *http.Request) {
 	helper.CheckErr(o.Printer, err)
 }
 
+func (o *ServerOptions) serveRewrittenUpdateCenter(w http.ResponseWriter, providerURL string,
+	query UpdateCenterQuery) (err error) {
+	// the values from the client are not a part of the key, every new key costs a fetch and a signature
+	externalURL, provider := o.GetExternalURL(), o.resolveProvider(query.Provider)
+	key := strings.Join([]string{providerURL, externalURL, provider}, " ")
+
+	// the cached one is signed already, so it's signed once for each change of the update center
+	var data []byte
+	data, err = o.RewriteCache.Get(providerURL, key, func(data []byte) (result []byte, err error) {
+		if result, err = RewriteUpdateCenter(data, externalURL, provider); err == nil && o.Signer != nil {
+			result, err = o.Signer.Sign(result)
+		}
+		return
+	})
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		_, err = w.Write([]byte(fmt.Sprintf("%v", err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/javascript")
+	_, err = w.Write(WrapUpdateCenterJSONP(data))
+	return
+}
+
 // HandleJSONServers handle /json-servers
 func HandleJSONServers(w http.ResponseWriter, r *http.Request) {
 	o := r.Context().Value(context.TODO()).(ServerOptions)
 	servers := GetJSONServers()
+	defaultJSONServer := o.GetDefaultJSONServer()
 	if _, ok := servers["default"]; ok {
-		servers["default"] = o.DefaultJSONServer
+		servers["default"] = defaultJSONServer
 	} else {
 		match := false
 		for _, val := range servers {
-			if val == o.DefaultJSONServer {
+			if val == defaultJSONServer {
 				match = true
 				break
 			}
 		}
 
 		if !match {
-			servers["default"] = o.DefaultJSONServer
+			servers["default"] = defaultJSONServer
 		}
 	}
 
@@ -124,16 +158,9 @@ func HandleJSONServers(w http.ResponseWriter, r *http.Request) {
 func HandleProviders(w http.ResponseWriter, r *http.Request) {
 	o := r.Context().Value(context.TODO()).(ServerOptions)
 
-	providers := GetProviders()
-	includeDefaultProvider := false
-	for _, provider := range providers {
-		if provider == o.DefaultProvider {
-			includeDefaultProvider = true
-			break
-		}
-	}
-
-	if !includeDefaultProvider {
+	registry := GetProviderRegistry()
+	providers := registry.Names()
+	if !registry.Has(o.DefaultProvider) {
 		providers = append(providers, o.DefaultProvider)
 	}
 
@@ -159,36 +186,114 @@ func HandleDefaultProvider(w http.ResponseWriter, r *http.Request) {
 func HandlePluginDownload(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 
-	var providerHost string
-	provider := queryValues.Get("provider")
-	switch provider {
-	default:
-		provider = "tsinghua"
-		providerHost = "https://mirrors.tuna.tsinghua.edu.cn"
+	o := r.Context().Value(context.TODO()).(ServerOptions)
+	provider := o.GetProvider(queryValues.Get("provider"))
+
+	uri := r.URL.EscapedPath()
+	artifactPath := strings.TrimPrefix(uri, "/jenkins/")
+
+	// the core downloads (/jenkins/war/) are not counted as a plugin
+	if strings.HasPrefix(artifactPath, "plugins/") {
+		index := strings.LastIndex(uri, "/")
+		pluginName := uri[index+1:]
+		pluginName = strings.Split(pluginName, ".")[0]
+
+		o.recordDownload(DownloadCount{
+			Plugin:   pluginName,
+			Provider: provider.Name,
+			Version:  GetJenkinsVersion(r),
+		})
+		if IsPluginName(pluginName) {
+			o.Metrics.RecordPluginDownload(pluginName, provider.Name)
+		}
 	}
 
-	uri := r.RequestURI
-	uri = strings.Split(uri, "?")[0]
+	fields := GetRequestFields(r)
+	fields.Provider, fields.Target = provider.Name, provider.GetArtifactURL(artifactPath)
+	if o.ArtifactCache != nil {
+		helper.CheckErr(o.Printer, o.ArtifactCache.Serve(w, r, artifactPath, provider.GetArtifactURL(artifactPath)))
+		return
+	}
+
+	w.Header().Set("Location", provider.GetArtifactURL(artifactPath))
+	w.WriteHeader(http.StatusMovedPermanently)
+}
 
+// HandleArtifactCacheStats returns the hit and miss counts of the artifact cache
+func HandleArtifactCacheStats(w http.ResponseWriter, r *http.Request) {
 	o := r.Context().Value(context.TODO()).(ServerOptions)
-	o.WorkPool.AddTask(Task{
-		TaskFunc: func(_ interface{}) {
-			pluginDownloadCounter := &GitPluginDownloadCounter{
-				Path: o.DataFilePath,
-			}
 
-			index := strings.LastIndex(uri, "/")
-			pluginName := uri[index+1:]
-			pluginName = strings.Split(pluginName, ".")[0]
+	var stats ArtifactCacheStats
+	if o.ArtifactCache != nil {
+		stats = o.ArtifactCache.Stats()
+	}
+
+	data, err := json.Marshal(stats)
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(data)
+	}
+	helper.CheckErr(o.Printer, err)
+}
+
+// recordDownload counts once by the aggregator, or saves it into the counter directly.
+// The invalid Jenkins version is dropped, the count is dropped if the plugin name is invalid
+func (o *ServerOptions) recordDownload(count DownloadCount) {
+	if !IsPluginName(count.Plugin) {
+		logger.Debug("drop the download of an invalid plugin name", zap.String("plugin", count.Plugin))
+		return
+	}
+	count.Date, count.Count = GetDate(), 1
+	if !IsJenkinsVersion(count.Version) {
+		count.Version = ""
+	}
+	if o.Aggregator != nil {
+		o.Aggregator.Add(count)
+		return
+	}
 
-			if err := pluginDownloadCounter.RecordPluginDownloadData(pluginName, provider); err != nil {
-				fmt.Println(err)
+	o.WorkPool.AddTask(Task{
+		TaskFunc: func(_ interface{}) {
+			pluginDownloadCounter := o.GetPluginDownloadCounter()
+			if err := pluginDownloadCounter.SaveCounts([]DownloadCount{count}); err != nil {
+				logger.Error("cannot save the download count", zap.String("plugin", count.Plugin), zap.Error(err))
 			}
 		},
 	})
+}
 
-	w.Header().Set("Location", fmt.Sprintf("%s%s", providerHost, uri))
-	w.WriteHeader(http.StatusMovedPermanently)
+// jenkinsVersionPattern matches the Jenkins versions, such as 2.263 and 2.263.1
+var jenkinsVersionPattern = regexp.MustCompile(`^[0-9]{1,4}(\.[0-9]{1,4}){1,3}$`)
+
+// IsJenkinsVersion checks if it's a valid Jenkins version
+func IsJenkinsVersion(version string) bool {
+	return jenkinsVersionPattern.MatchString(version)
+}
+
+// pluginNamePattern matches the plugin names, such as git and workflow-aggregator
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,127}$`)
+
+// IsPluginName checks if it's a valid plugin name
+func IsPluginName(name string) bool {
+	return pluginNamePattern.MatchString(name)
+}
+
+// GetJenkinsVersion returns the Jenkins version from the query or the User-Agent (Jenkins/2.263.1)
+func GetJenkinsVersion(r *http.Request) (version string) {
+	if version = r.URL.Query().Get("version"); version != "" {
+		return
+	}
+	return getJenkinsVersionFromUserAgent(r.UserAgent())
+}
+
+func getJenkinsVersionFromUserAgent(userAgent string) (version string) {
+	for _, item := range strings.Fields(userAgent) {
+		if strings.HasPrefix(item, "Jenkins/") {
+			version = strings.TrimPrefix(item, "Jenkins/")
+			break
+		}
+	}
+	return
 }
 
 // HandlePluginsData returns the data of a plugin
@@ -197,6 +302,7 @@ func HandlePluginsData(w http.ResponseWriter, r *http.Request) {
 
 	year := queryValues.Get("year")
 	name := queryValues.Get("name")
+	provider := queryValues.Get("provider")
 
 	// use current year as the default
 	if year == "" {
@@ -208,30 +314,58 @@ func HandlePluginsData(w http.ResponseWriter, r *http.Request) {
 		name = "update-center"
 	}
 
-	fmt.Println("plugin", name, "year", year)
+	logger.Debug("query the plugin data", zap.String("plugin", name), zap.String("year", year))
 
 	// get plugin data
 	o := r.Context().Value(context.TODO()).(ServerOptions)
-	pluginDownloadCounter := &GitPluginDownloadCounter{
-		Path: o.DataFilePath,
+	pluginDownloadCounter := o.GetPluginDownloadCounter()
+
+	from, to, granularity := queryValues.Get("from"), queryValues.Get("to"), queryValues.Get("granularity")
+	if from != "" || to != "" || granularity != "" {
+		handlePluginStatistics(w, pluginDownloadCounter, name, provider, from, to, granularity)
+		return
 	}
 
 	pluginData, err := pluginDownloadCounter.FindPluginData(year, name)
 
 	responseData := ResponseData{
-		Data: pluginData,
+		Data:  pluginData.FilterByProvider(provider),
 		Error: err,
 	}
 
 	var data []byte
 	if data, err = json.Marshal(responseData); err != nil {
-		fmt.Println(err)
+		logger.Error("cannot marshal the response", zap.Error(err))
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Write(data)
 }
 
+// handlePluginStatistics returns the summed counts of a plugin in a date range
+func handlePluginStatistics(w http.ResponseWriter, counter PluginDownloadCounter, name, provider, from, to, granularity string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, err := ParseStatisticsQuery(name, provider, from, to, granularity)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("%v", err)))
+		return
+	}
+
+	stats, err := QueryPluginStatistics(counter, query)
+	responseData := ResponseData{
+		Data:  stats,
+		Error: err,
+	}
+
+	var data []byte
+	if data, err = json.Marshal(responseData); err != nil {
+		logger.Error("cannot marshal the response", zap.Error(err))
+	}
+	w.Write(data)
+}
+
 func HandlePluginsDataList(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 
@@ -244,11 +378,9 @@ func HandlePluginsDataList(w http.ResponseWriter, r *http.Request) {
 
 	// get plugin data
 	o := r.Context().Value(context.TODO()).(ServerOptions)
-	pluginDownloadCounter := &GitPluginDownloadCounter{
-		Path: o.DataFilePath,
-	}
+	pluginDownloadCounter := o.GetPluginDownloadCounter()
 
-	responseData := ResponseData {}
+	responseData := ResponseData{}
 	if downloadData, err := pluginDownloadCounter.FindByYear(year); err == nil {
 		plugins := make([]string, 0)
 		for key, _ := range downloadData.Plugins {
@@ -263,19 +395,112 @@ func HandlePluginsDataList(w http.ResponseWriter, r *http.Request) {
 	var data []byte
 	var err error
 	if data, err = json.Marshal(responseData); err != nil {
-		fmt.Println(err)
+		logger.Error("cannot marshal the response", zap.Error(err))
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Write(data)
 }
 
-// HandleHealthCheck indicate server status
+// HandlePluginsTop ranks the plugins by downloads, GET /plugins/top?from=2020-01-01&to=2020-01-31&limit=10&growth=true
+func HandlePluginsTop(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query, err := ParseStatisticsQuery("", queryValues.Get("provider"), queryValues.Get("from"),
+		queryValues.Get("to"), "")
+
+	limit, offset := 10, 0
+	if err == nil && queryValues.Get("limit") != "" {
+		limit, err = strconv.Atoi(queryValues.Get("limit"))
+	}
+	if err == nil && queryValues.Get("offset") != "" {
+		offset, err = strconv.Atoi(queryValues.Get("offset"))
+	}
+	if err == nil && (limit < 0 || offset < 0) {
+		err = fmt.Errorf("limit and offset cannot be negative")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("%v", err)))
+		return
+	}
+
+	o := r.Context().Value(context.TODO()).(ServerOptions)
+	ranking := RankPlugins(o.GetPluginDownloadCounter(), query, limit, offset, queryValues.Get("growth") == "true")
+
+	var data []byte
+	if data, err = json.Marshal(ResponseData{Data: ranking}); err != nil {
+		logger.Error("cannot marshal the response", zap.Error(err))
+	}
+	w.Write(data)
+}
+
+// HandlePluginsExport streams the download counts of all plugins in a date range,
+// GET /plugins/export?format=csv&from=2020-01-01&to=2020-12-31
+func HandlePluginsExport(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	o := r.Context().Value(context.TODO()).(ServerOptions)
+
+	format := queryValues.Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+
+	query, err := ParseStatisticsQuery("", queryValues.Get("provider"), queryValues.Get("from"),
+		queryValues.Get("to"), "")
+	contentType, ok := ExportContentTypes[format]
+	if err == nil && !ok {
+		err = fmt.Errorf("unknown export format: %s", format)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("%v", err)))
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=plugins-%s-%s.%s",
+		query.From.Format(dateLayout), query.To.Format(dateLayout), format))
+	helper.CheckErr(o.Printer, ExportDownloadCounts(w, o.GetPluginDownloadCounter(), query, format))
+}
+
+// HandleMetrics exposes the counters in the Prometheus text format
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	o := r.Context().Value(context.TODO()).(ServerOptions)
+
+	metrics := o.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := metrics.WriteTo(w)
+	helper.CheckErr(o.Printer, err)
+}
+
+// HandleHealthCheck indicate server status, the status of providers and
+// JSON servers will be returned if the health checking is enabled
 func HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("ok"))
+	o, _ := r.Context().Value(context.TODO()).(ServerOptions)
+	if o.HealthChecker == nil {
+		w.Write([]byte("ok"))
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"status":  "ok",
+		"targets": o.HealthChecker.GetStatus(),
+	})
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(data)
+	}
+	helper.CheckErr(o.Printer, err)
 }
 
 type ResponseData struct {
-	Data interface{}
+	Data  interface{}
 	Error error
 }
@@ -2,12 +2,18 @@ package pkg_test
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	server "github.com/jenkins-zh/mirror-proxy/pkg"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
 )
 
 var _ = Describe("test server", func() {
@@ -24,14 +30,27 @@ var _ = Describe("test server", func() {
 		bodyErr  error
 
 		reqHandler http.HandlerFunc
+		dataDir    string
 	)
 
 	BeforeEach(func() {
-		option = server.ServerOptions{}
+		var err error
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the download counts are saved into the temporary directory instead of the source tree
+		option = server.ServerOptions{DataFilePath: dataDir}
 		option.WorkPool = &server.WorkPool{}
 		option.WorkPool.InitPool(5)
 	})
 
+	AfterEach(func() {
+		if option.WorkPool != nil {
+			option.WorkPool.ClosePool()
+		}
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
 	JustBeforeEach(func() {
 		request, reqErr = http.NewRequest("GET", api, nil)
 		Expect(reqErr).To(BeNil())
@@ -49,14 +68,123 @@ var _ = Describe("test server", func() {
 	})
 
 	Context("HandleUpdateCenter", func() {
+		var officialUpstream *httptest.Server
+
 		BeforeEach(func() {
 			api = "/update-center.json"
 			reqHandler = server.HandleUpdateCenter
+
+			officialUpstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "https://updates.jenkins.io/current/update-center.json", http.StatusFound)
+			}))
+			option.Upstream = officialUpstream.URL
+			option.Cache, _ = server.NewMemoryCacheServer(time.Hour, nil)
+		})
+
+		AfterEach(func() {
+			officialUpstream.Close()
 		})
 
 		It("should success", func() {
 			Expect(recorder.Code).To(Equal(http.StatusMovedPermanently))
 		})
+
+		Context("record the download", func() {
+			var counter *FakeCounter
+
+			BeforeEach(func() {
+				api = "/update-center.json?version=2.263.1&mirror-provider=unknown"
+				counter = &FakeCounter{}
+				option.DefaultProvider = "tsinghua"
+				option.Aggregator = server.NewDownloadAggregator(counter, time.Hour)
+			})
+
+			It("should record the resolved provider", func() {
+				Expect(option.Aggregator.Flush()).To(Succeed())
+				Expect(counter.Batches).To(Equal([][]server.DownloadCount{{{
+					Plugin: "update-center", Provider: "tsinghua", Version: "2.263.1", Date: server.GetDate(), Count: 1,
+				}}}))
+			})
+
+			Context("with an invalid version", func() {
+				BeforeEach(func() {
+					api = "/update-center.json?version=2.263.1%3Cscript%3E"
+				})
+
+				It("should drop the version", func() {
+					Expect(option.Aggregator.Flush()).To(Succeed())
+					Expect(counter.Batches[0][0].Version).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("rewrite the update center", func() {
+			var (
+				upstream *httptest.Server
+			)
+
+			BeforeEach(func() {
+				api = "/update-center.json?mirror-experimental=true&mirror-provider=tsinghua"
+				upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte(`updateCenter.post(
+{"plugins":{"git":{"url":"https://updates.jenkins.io/download/plugins/git/4.4.5/git.hpi"}}}
+);`))
+				}))
+				viper.Set("providers", []string{"tsinghua"})
+				option.DefaultJSONServer = upstream.URL
+				option.RewriteUpdateCenter = true
+				option.ExternalURL = "https://proxy.example.com"
+			})
+
+			AfterEach(func() {
+				upstream.Close()
+				viper.Reset()
+			})
+
+			It("should return the rewritten update center", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Header().Get("Content-Type")).To(Equal("application/javascript"))
+				Expect(string(bodyData)).To(Equal(`updateCenter.post(
+{"plugins":{"git":{"url":"https://proxy.example.com/jenkins/plugins/git/4.4.5/git.hpi?provider=tsinghua"}}}
+);
+`))
+			})
+
+			Context("with the cache and signer", func() {
+				BeforeEach(func() {
+					key, err := rsa.GenerateKey(rand.Reader, 1024)
+					Expect(err).NotTo(HaveOccurred())
+					option.Signer = &server.UpdateCenterSigner{Key: key}
+					option.RewriteCache = server.NewRewrittenUpdateCenters(time.Hour)
+				})
+
+				It("should serve the signed one from the cache", func() {
+					Expect(recorder.Code).To(Equal(http.StatusOK))
+					Expect(string(bodyData)).To(ContainSubstring(`"correct_signature512"`))
+
+					// the cached one is served without fetching and signing again
+					upstream.Close()
+					cachedRecorder := httptest.NewRecorder()
+					reqHandler.ServeHTTP(cachedRecorder, request)
+					Expect(cachedRecorder.Code).To(Equal(http.StatusOK))
+					Expect(cachedRecorder.Body.String()).To(Equal(string(bodyData)))
+				})
+
+				It("should serve the cached one for an unknown provider or host", func() {
+					upstream.Close()
+					unknown, err := http.NewRequest(http.MethodGet,
+						"/update-center.json?mirror-experimental=true&mirror-provider=unknown", nil)
+					Expect(err).NotTo(HaveOccurred())
+					unknown.Host = "evil.example.com"
+					unknown = unknown.WithContext(request.Context())
+
+					cachedRecorder := httptest.NewRecorder()
+					reqHandler.ServeHTTP(cachedRecorder, unknown)
+					Expect(cachedRecorder.Code).To(Equal(http.StatusOK))
+					Expect(cachedRecorder.Body.String()).To(Equal(string(bodyData)))
+				})
+			})
+		})
 	})
 
 	Context("HandleJSONServers", func() {
@@ -131,6 +259,190 @@ var _ = Describe("test server", func() {
 		})
 	})
 
+	Context("HandlePluginDownload", func() {
+		BeforeEach(func() {
+			api = "/jenkins/plugins/git/4.4.5/git.hpi?provider=internal"
+			reqHandler = server.HandlePluginDownload
+		})
+
+		AfterEach(func() {
+			viper.Reset()
+		})
+
+		It("redirect to the default provider", func() {
+			Expect(recorder.Code).To(Equal(http.StatusMovedPermanently))
+			Expect(recorder.Header().Get("Location")).
+				To(Equal("https://mirrors.tuna.tsinghua.edu.cn/jenkins/plugins/git/4.4.5/git.hpi"))
+		})
+
+		Context("with a configured provider", func() {
+			BeforeEach(func() {
+				viper.Set("providerMirrors", map[string]interface{}{
+					"internal": map[string]interface{}{
+						"url":    "https://repo.example.com",
+						"layout": "/mirror/{path}",
+					},
+				})
+			})
+
+			It("redirect to the configured provider", func() {
+				Expect(recorder.Code).To(Equal(http.StatusMovedPermanently))
+				Expect(recorder.Header().Get("Location")).
+					To(Equal("https://repo.example.com/mirror/plugins/git/4.4.5/git.hpi"))
+			})
+		})
+
+		Context("record the download", func() {
+			var counter *FakeCounter
+
+			BeforeEach(func() {
+				counter = &FakeCounter{}
+				option.Aggregator = server.NewDownloadAggregator(counter, time.Hour)
+			})
+
+			It("should record the plugin", func() {
+				Expect(option.Aggregator.Flush()).To(Succeed())
+				Expect(counter.Batches).To(HaveLen(1))
+				Expect(counter.Batches[0][0].Plugin).To(Equal("git"))
+			})
+
+			Context("without the plugin name", func() {
+				BeforeEach(func() {
+					api = "/jenkins/plugins/"
+				})
+
+				It("should not record it", func() {
+					Expect(option.Aggregator.Pending()).To(BeZero())
+				})
+			})
+
+			Context("download the core", func() {
+				BeforeEach(func() {
+					api = "/jenkins/war/2.263.1/jenkins.war"
+				})
+
+				It("should not record it as a plugin", func() {
+					Expect(recorder.Code).To(Equal(http.StatusMovedPermanently))
+					Expect(option.Aggregator.Pending()).To(BeZero())
+				})
+			})
+		})
+	})
+
+	Context("HandlePluginsData", func() {
+		BeforeEach(func() {
+			dir, err := ioutil.TempDir("", "plugins")
+			Expect(err).NotTo(HaveOccurred())
+
+			option.DataFilePath = dir
+			Expect(option.GetPluginDownloadCounter().SaveCounts([]server.DownloadCount{
+				{Plugin: "git", Date: "2019-12-31", Count: 1},
+				{Plugin: "git", Date: "2020-01-01", Count: 2},
+			})).To(Succeed())
+			reqHandler = server.HandlePluginsData
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(option.DataFilePath)).To(Succeed())
+		})
+
+		Context("with a date range", func() {
+			BeforeEach(func() {
+				api = "/plugins?name=git&from=2019-12-01&to=2020-01-31&granularity=month"
+			})
+
+			It("should return the summed counts", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(string(bodyData)).To(Equal(`{"Data":{"Plugin":"git","From":"2019-12-01","To":"2020-01-31",` +
+					`"Granularity":"month","Data":{"2019-12":1,"2020-01":2},"Total":3},"Error":null}`))
+			})
+		})
+
+		Context("with an invalid date", func() {
+			BeforeEach(func() {
+				api = "/plugins?name=git&from=2019"
+			})
+
+			It("should be a bad request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("HandlePluginsTop", func() {
+		BeforeEach(func() {
+			dir, err := ioutil.TempDir("", "plugins")
+			Expect(err).NotTo(HaveOccurred())
+
+			option.DataFilePath = dir
+			Expect(option.GetPluginDownloadCounter().SaveCounts([]server.DownloadCount{
+				{Plugin: "git", Date: "2020-01-01", Count: 2},
+				{Plugin: "maven", Date: "2020-01-01", Count: 1},
+			})).To(Succeed())
+			reqHandler = server.HandlePluginsTop
+			api = "/plugins/top?from=2020-01-01&to=2020-01-31&limit=1"
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(option.DataFilePath)).To(Succeed())
+		})
+
+		It("should return the top plugins", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(string(bodyData)).To(Equal(`{"Data":{"From":"2020-01-01","To":"2020-01-31","Total":2,` +
+				`"Plugins":[{"Plugin":"git","Downloads":2}]},"Error":null}`))
+		})
+
+		Context("with an invalid limit", func() {
+			BeforeEach(func() {
+				api = "/plugins/top?limit=-1"
+			})
+
+			It("should be a bad request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("HandlePluginsExport", func() {
+		BeforeEach(func() {
+			reqHandler = server.HandlePluginsExport
+			api = "/plugins/export?format=ndjson&from=2010-01-01&to=2010-01-31"
+		})
+
+		It("should stream the counts", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+			Expect(recorder.Header().Get("Content-Disposition")).
+				To(Equal("attachment; filename=plugins-2010-01-01-2010-01-31.ndjson"))
+		})
+
+		Context("with an unknown format", func() {
+			BeforeEach(func() {
+				api = "/plugins/export?format=xml"
+			})
+
+			It("should be a bad request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("HandleMetrics", func() {
+		BeforeEach(func() {
+			reqHandler = server.HandleMetrics
+			api = "/metrics"
+			option.Metrics = server.NewMetrics()
+			option.Metrics.RecordPluginDownload("git", "tsinghua")
+		})
+
+		It("should return the counters", func() {
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+			Expect(string(bodyData)).
+				To(ContainSubstring(`mirror_proxy_plugin_downloads_total{plugin="git",provider="tsinghua"} 1`))
+		})
+	})
+
 	Context("HandleHealthCheck", func() {
 		BeforeEach(func() {
 			api = "/status"
@@ -140,6 +452,65 @@ var _ = Describe("test server", func() {
 		It("should return ok", func() {
 			Expect(string(bodyData)).To(Equal("ok"))
 		})
+
+		Context("with health checking", func() {
+			BeforeEach(func() {
+				option.HealthChecker = server.NewHealthChecker(time.Minute, time.Second, func() []server.ProbeTarget {
+					return nil
+				})
+			})
+
+			It("should return the status of targets", func() {
+				Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+				Expect(string(bodyData)).To(Equal(`{"status":"ok","targets":[]}`))
+			})
+		})
+	})
+})
+
+var _ = Describe("GetJenkinsVersion", func() {
+	It("from the query", func() {
+		request, err := http.NewRequest(http.MethodGet, "/update-center.json?version=2.263.1", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.GetJenkinsVersion(request)).To(Equal("2.263.1"))
+	})
+
+	It("from the User-Agent", func() {
+		request, err := http.NewRequest(http.MethodGet, "/jenkins/plugins/git/4.4.5/git.hpi", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.GetJenkinsVersion(request)).To(BeEmpty())
+
+		request.Header.Set("User-Agent", "Jenkins/2.263.1 (Linux)")
+		Expect(server.GetJenkinsVersion(request)).To(Equal("2.263.1"))
+	})
+})
+
+var _ = Describe("IsJenkinsVersion", func() {
+	It("valid versions", func() {
+		Expect(server.IsJenkinsVersion("2.263")).To(BeTrue())
+		Expect(server.IsJenkinsVersion("2.263.1")).To(BeTrue())
+	})
+
+	It("invalid versions", func() {
+		Expect(server.IsJenkinsVersion("")).To(BeFalse())
+		Expect(server.IsJenkinsVersion("2")).To(BeFalse())
+		Expect(server.IsJenkinsVersion("2.263.1-SNAPSHOT")).To(BeFalse())
+		Expect(server.IsJenkinsVersion("2.263.1.1.1")).To(BeFalse())
+	})
+})
+
+var _ = Describe("IsPluginName", func() {
+	It("valid names", func() {
+		Expect(server.IsPluginName("git")).To(BeTrue())
+		Expect(server.IsPluginName("workflow-aggregator")).To(BeTrue())
+		Expect(server.IsPluginName("TwilioNotifier")).To(BeTrue())
+	})
+
+	It("invalid names", func() {
+		Expect(server.IsPluginName("")).To(BeFalse())
+		Expect(server.IsPluginName("-git")).To(BeFalse())
+		Expect(server.IsPluginName("git%3Cscript%3E")).To(BeFalse())
+		Expect(server.IsPluginName(strings.Repeat("a", 129))).To(BeFalse())
 	})
 })
 
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ShutdownServers stops accepting the connections, then waits for the in-flight requests until the timeout.
+// The servers are closed forcibly if the requests are not done in time
+func ShutdownServers(timeout time.Duration, servers ...*http.Server) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+
+		wait.Add(1)
+		go func(server *http.Server) {
+			defer wait.Done()
+			if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+				logger.Warn("cannot wait for the in-flight requests", zap.String("address", server.Addr), zap.Error(shutdownErr))
+				_ = server.Close()
+
+				lock.Lock()
+				err = shutdownErr
+				lock.Unlock()
+			}
+		}(server)
+	}
+	wait.Wait()
+	return
+}
@@ -0,0 +1,68 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+var _ = Describe("ShutdownServers", func() {
+	var (
+		listener net.Listener
+		srv      *http.Server
+		delay    time.Duration
+		err      error
+	)
+
+	BeforeEach(func() {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		delay = 200 * time.Millisecond
+		srv = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(delay)
+			_, _ = w.Write([]byte("done"))
+		})}
+		go func() {
+			_ = srv.Serve(listener)
+		}()
+	})
+
+	request := func() <-chan string {
+		result := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			response, requestErr := http.Get("http://" + listener.Addr().String())
+			if requestErr != nil {
+				result <- requestErr.Error()
+				return
+			}
+			data, _ := ioutil.ReadAll(response.Body)
+			_ = response.Body.Close()
+			result <- string(data)
+		}()
+		// wait for the request being in-flight
+		time.Sleep(50 * time.Millisecond)
+		return result
+	}
+
+	It("wait for the in-flight requests", func() {
+		result := request()
+		Expect(server.ShutdownServers(time.Second, srv, nil)).To(Succeed())
+		Expect(<-result).To(Equal("done"))
+
+		_, err = http.Get("http://" + listener.Addr().String())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("close the server after the timeout", func() {
+		delay = 2 * time.Second
+		result := request()
+		Expect(server.ShutdownServers(100*time.Millisecond, srv)).NotTo(Succeed())
+		Expect(<-result).NotTo(Equal("done"))
+	})
+})
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// UpdateCenterSigner signs the update center the same way as the Jenkins update center does
+type UpdateCenterSigner struct {
+	Key          *rsa.PrivateKey
+	Certificates []*x509.Certificate
+}
+
+// NewUpdateCenterSigner loads the certificate chain and the RSA private key from PEM files
+func NewUpdateCenterSigner(certFile, keyFile string) (signer *UpdateCenterSigner, err error) {
+	var certData, keyData []byte
+	if certData, err = ioutil.ReadFile(certFile); err != nil {
+		return
+	}
+	if keyData, err = ioutil.ReadFile(keyFile); err != nil {
+		return
+	}
+
+	signer = &UpdateCenterSigner{}
+	for block, rest := pem.Decode(certData); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return
+		}
+		signer.Certificates = append(signer.Certificates, cert)
+	}
+	if len(signer.Certificates) == 0 {
+		err = fmt.Errorf("cannot find any certificate in %s", certFile)
+		return
+	}
+
+	signer.Key, err = parseRSAPrivateKey(keyData)
+	return
+}
+
+func parseRSAPrivateKey(data []byte) (key *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = fmt.Errorf("cannot find any PEM block of the private key")
+		return
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	var parsed interface{}
+	if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			err = fmt.Errorf("only the RSA private key is supported")
+		}
+	}
+	return
+}
+
+// Sign replaces the signature block of the update center JSON
+func (s *UpdateCenterSigner) Sign(data []byte) (result []byte, err error) {
+	updateCenter := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&updateCenter); err != nil {
+		return
+	}
+	delete(updateCenter, "signature")
+
+	var canonical []byte
+	if canonical, err = CanonicalizeJSON(updateCenter); err != nil {
+		return
+	}
+
+	digest := sha1.Sum(canonical)
+	digest512 := sha512.Sum512(canonical)
+
+	var signature, signature512 []byte
+	if signature, err = rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA1, digest[:]); err != nil {
+		return
+	}
+	if signature512, err = rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA512, digest512[:]); err != nil {
+		return
+	}
+
+	certificates := make([]interface{}, 0, len(s.Certificates))
+	for _, cert := range s.Certificates {
+		certificates = append(certificates, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+
+	updateCenter["signature"] = map[string]interface{}{
+		"certificates":         certificates,
+		"correct_digest":       base64.StdEncoding.EncodeToString(digest[:]),
+		"correct_digest512":    hex.EncodeToString(digest512[:]),
+		"correct_signature":    base64.StdEncoding.EncodeToString(signature),
+		"correct_signature512": hex.EncodeToString(signature512),
+	}
+	return CanonicalizeJSON(updateCenter)
+}
+
+// CanonicalizeJSON writes the JSON in the canonical form which Jenkins uses to verify the signature:
+// no whitespace, sorted keys, and only the quotes, backslashes and control characters are escaped.
+// The numbers must be decoded as json.Number to keep them as they are
+func CanonicalizeJSON(value interface{}) (data []byte, err error) {
+	buf := &bytes.Buffer{}
+	err = writeCanonical(buf, value)
+	data = buf.Bytes()
+	return
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) (err error) {
+	switch val := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if val {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case json.Number:
+		buf.WriteString(val.String())
+	case string:
+		writeCanonicalString(buf, val)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err = writeCanonical(buf, item); err != nil {
+				return
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err = writeCanonical(buf, val[key]); err != nil {
+				return
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		err = fmt.Errorf("unsupported JSON value type: %T", value)
+	}
+	return
+}
+
+func writeCanonicalString(buf *bytes.Buffer, val string) {
+	buf.WriteByte('"')
+	for _, char := range val {
+		switch {
+		case char == '"':
+			buf.WriteString(`\"`)
+		case char == '\\':
+			buf.WriteString(`\\`)
+		case char < 0x20:
+			buf.WriteString(fmt.Sprintf(`\u%04x`, char))
+		default:
+			buf.WriteRune(char)
+		}
+	}
+	buf.WriteByte('"')
+}
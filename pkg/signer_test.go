@@ -0,0 +1,129 @@
+package pkg_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("CanonicalizeJSON", func() {
+	It("should sort the keys without whitespace", func() {
+		value := map[string]interface{}{}
+		decoder := json.NewDecoder(bytes.NewBufferString(`{"b": [1, 2.50, true, null], "a": {"d": "<&\"\\\n中>", "c": 1e3}}`))
+		decoder.UseNumber()
+		Expect(decoder.Decode(&value)).To(Succeed())
+
+		data, err := server.CanonicalizeJSON(value)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`{"a":{"c":1e3,"d":"<&\"\\\u000a中>"},"b":[1,2.50,true,null]}`))
+	})
+
+	It("unsupported type", func() {
+		_, err := server.CanonicalizeJSON(map[string]interface{}{"a": 1})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("UpdateCenterSigner", func() {
+	var (
+		dir    string
+		key    *rsa.PrivateKey
+		cert   *x509.Certificate
+		signer *server.UpdateCenterSigner
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "signer")
+		Expect(err).NotTo(HaveOccurred())
+
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "mirror-proxy"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		var der []byte
+		der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).NotTo(HaveOccurred())
+		cert, err = x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "uc.crt"),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "uc.key"),
+			pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		signer, err = server.NewUpdateCenterSigner(filepath.Join(dir, "uc.crt"), filepath.Join(dir, "uc.key"))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("should load the certificate and key", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(signer.Certificates)).To(Equal(1))
+		Expect(signer.Key.N).To(Equal(key.N))
+	})
+
+	It("should sign the update center", func() {
+		Expect(err).NotTo(HaveOccurred())
+
+		var data []byte
+		data, err = signer.Sign([]byte(`{"id":"default","plugins":{},"signature":{"digest":"old"}}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		result := map[string]interface{}{}
+		Expect(json.Unmarshal(data, &result)).To(Succeed())
+		signature := result["signature"].(map[string]interface{})
+		Expect(signature["certificates"]).To(Equal([]interface{}{base64.StdEncoding.EncodeToString(cert.Raw)}))
+
+		canonical := []byte(`{"id":"default","plugins":{}}`)
+		digest := sha1.Sum(canonical)
+		digest512 := sha512.Sum512(canonical)
+		Expect(signature["correct_digest"]).To(Equal(base64.StdEncoding.EncodeToString(digest[:])))
+		Expect(signature["correct_digest512"]).To(Equal(hex.EncodeToString(digest512[:])))
+
+		var sig []byte
+		sig, err = base64.StdEncoding.DecodeString(signature["correct_signature"].(string))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA1, digest[:], sig)).To(Succeed())
+
+		sig, err = hex.DecodeString(signature["correct_signature512"].(string))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA512, digest512[:], sig)).To(Succeed())
+	})
+
+	Context("without certificate", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "uc.crt"), []byte("fake"), 0600)).To(Succeed())
+		})
+
+		It("should return an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// GranularityDay sums the counts by day, the key is formatted as 2006-01-02
+	GranularityDay = "day"
+	// GranularityWeek sums the counts by ISO week, the key is the Monday of the week
+	GranularityWeek = "week"
+	// GranularityMonth sums the counts by month, the key is formatted as 2006-01
+	GranularityMonth = "month"
+)
+
+const dateLayout = "2006-01-02"
+
+// MaxStatisticsYears is the max length of the range, the data of each year in the range is read for a query
+const MaxStatisticsYears = 10
+
+// StatisticsQuery represents the range and the granularity of the download counts
+type StatisticsQuery struct {
+	Plugin      string
+	Provider    string
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+// PluginStatistics is the aggregated download counts of a plugin
+type PluginStatistics struct {
+	Plugin      string
+	From        string
+	To          string
+	Granularity string
+	Data        map[string]int64
+	Total       int64
+}
+
+// ParseStatisticsQuery parses the from and to dates, the default range is the current year to today
+func ParseStatisticsQuery(plugin, provider, from, to, granularity string) (query StatisticsQuery, err error) {
+	query = StatisticsQuery{
+		Plugin:      plugin,
+		Provider:    provider,
+		Granularity: granularity,
+	}
+
+	if query.Granularity == "" {
+		query.Granularity = GranularityDay
+	}
+	switch query.Granularity {
+	case GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		err = fmt.Errorf("unknown granularity: %s", granularity)
+		return
+	}
+
+	if to == "" {
+		query.To, _ = time.Parse(dateLayout, GetDate())
+	} else if query.To, err = time.Parse(dateLayout, to); err != nil {
+		err = fmt.Errorf("invalid date of to: %s", to)
+		return
+	}
+
+	if from == "" {
+		query.From = time.Date(query.To.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	} else if query.From, err = time.Parse(dateLayout, from); err != nil {
+		err = fmt.Errorf("invalid date of from: %s", from)
+		return
+	}
+
+	if query.From.After(query.To) {
+		err = fmt.Errorf("from %s is after to %s", query.From.Format(dateLayout), query.To.Format(dateLayout))
+	} else if !query.To.Before(query.From.AddDate(MaxStatisticsYears, 0, 0)) {
+		err = fmt.Errorf("the range from %s to %s is longer than %d years",
+			query.From.Format(dateLayout), query.To.Format(dateLayout), MaxStatisticsYears)
+	}
+	return
+}
+
+// QueryPluginStatistics sums the counts of a plugin in the range, the data of all the years in the range will be read
+func QueryPluginStatistics(counter PluginDownloadCounter, query StatisticsQuery) (stats PluginStatistics, err error) {
+	stats = PluginStatistics{
+		Plugin:      query.Plugin,
+		From:        query.From.Format(dateLayout),
+		To:          query.To.Format(dateLayout),
+		Granularity: query.Granularity,
+		Data:        map[string]int64{},
+	}
+
+	for year := query.From.Year(); year <= query.To.Year(); year++ {
+		downloadData, findErr := counter.FindByYear(strconv.Itoa(year))
+		if findErr != nil {
+			// there is no data of this year
+			continue
+		}
+
+		pluginData, ok := downloadData.Plugins[query.Plugin]
+		if !ok {
+			continue
+		}
+
+		for date, count := range pluginData.FilterByProvider(query.Provider).Data {
+			day, parseErr := time.Parse(dateLayout, date)
+			if parseErr != nil || day.Before(query.From) || day.After(query.To) {
+				continue
+			}
+
+			stats.Data[GetPeriod(day, query.Granularity)] += count
+			stats.Total += count
+		}
+	}
+	return
+}
+
+// PluginRank is the downloads of a plugin in the ranking
+type PluginRank struct {
+	Plugin    string
+	Downloads int64
+	// PreviousDownloads and Growth are the comparison with the previous window, Growth is nil if there is no download
+	PreviousDownloads *int64   `json:",omitempty"`
+	Growth            *float64 `json:",omitempty"`
+}
+
+// PluginRanking is a page of the plugins which are ranked by downloads
+type PluginRanking struct {
+	From  string
+	To    string
+	Total int
+	// PreviousFrom and PreviousTo is the window which has the same length before the chosen one
+	PreviousFrom string `json:",omitempty"`
+	PreviousTo   string `json:",omitempty"`
+	Plugins      []PluginRank
+}
+
+// RankPlugins ranks the plugins by downloads in the range, the update center visits are not included
+func RankPlugins(counter PluginDownloadCounter, query StatisticsQuery, limit, offset int, growth bool) (ranking PluginRanking) {
+	ranking = PluginRanking{
+		From:    query.From.Format(dateLayout),
+		To:      query.To.Format(dateLayout),
+		Plugins: []PluginRank{},
+	}
+
+	downloads := sumPluginDownloads(counter, query.Provider, query.From, query.To)
+	delete(downloads, "update-center")
+
+	ranks := make([]PluginRank, 0, len(downloads))
+	for plugin, count := range downloads {
+		ranks = append(ranks, PluginRank{Plugin: plugin, Downloads: count})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].Downloads != ranks[j].Downloads {
+			return ranks[i].Downloads > ranks[j].Downloads
+		}
+		return ranks[i].Plugin < ranks[j].Plugin
+	})
+
+	ranking.Total = len(ranks)
+	if offset >= len(ranks) {
+		return
+	}
+	ranks = ranks[offset:]
+	if limit > 0 && limit < len(ranks) {
+		ranks = ranks[:limit]
+	}
+
+	if growth {
+		days := int(query.To.Sub(query.From).Hours()/24) + 1
+		previousTo := query.From.AddDate(0, 0, -1)
+		previousFrom := previousTo.AddDate(0, 0, 1-days)
+		ranking.PreviousFrom, ranking.PreviousTo = previousFrom.Format(dateLayout), previousTo.Format(dateLayout)
+
+		previous := sumPluginDownloads(counter, query.Provider, previousFrom, previousTo)
+		for i := range ranks {
+			count := previous[ranks[i].Plugin]
+			ranks[i].PreviousDownloads = &count
+			if count > 0 {
+				rate := float64(ranks[i].Downloads-count) / float64(count)
+				ranks[i].Growth = &rate
+			}
+		}
+	}
+	ranking.Plugins = ranks
+	return
+}
+
+// sumPluginDownloads returns the downloads of all plugins in the range
+func sumPluginDownloads(counter PluginDownloadCounter, provider string, from, to time.Time) (downloads map[string]int64) {
+	downloads = map[string]int64{}
+	for year := from.Year(); year <= to.Year(); year++ {
+		downloadData, err := counter.FindByYear(strconv.Itoa(year))
+		if err != nil {
+			continue
+		}
+
+		for plugin, pluginData := range downloadData.Plugins {
+			for date, count := range pluginData.FilterByProvider(provider).Data {
+				if day, parseErr := time.Parse(dateLayout, date); parseErr == nil && !day.Before(from) && !day.After(to) {
+					downloads[plugin] += count
+				}
+			}
+		}
+	}
+	return
+}
+
+// GetPeriod returns the key of the period which the day belongs to
+func GetPeriod(day time.Time, granularity string) string {
+	switch granularity {
+	case GranularityWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset).Format(dateLayout)
+	case GranularityMonth:
+		return day.Format("2006-01")
+	default:
+		return day.Format(dateLayout)
+	}
+}
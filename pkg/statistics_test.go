@@ -0,0 +1,199 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var _ = Describe("QueryPluginStatistics", func() {
+	var (
+		dir     string
+		counter *server.GitPluginDownloadCounter
+		query   server.StatisticsQuery
+		stats   server.PluginStatistics
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "statistics")
+		Expect(err).NotTo(HaveOccurred())
+
+		counter = &server.GitPluginDownloadCounter{Path: dir}
+		Expect(counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Provider: "tsinghua", Date: "2019-12-30", Count: 1},
+			{Plugin: "git", Provider: "huawei", Date: "2019-12-31", Count: 2},
+			{Plugin: "git", Provider: "tsinghua", Date: "2020-01-01", Count: 3},
+			{Plugin: "git", Provider: "tsinghua", Date: "2020-01-06", Count: 4},
+			{Plugin: "git", Provider: "tsinghua", Date: "2020-02-01", Count: 5},
+			{Plugin: "maven", Date: "2020-01-01", Count: 6},
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		stats, err = server.QueryPluginStatistics(counter, query)
+	})
+
+	Context("by day across the years", func() {
+		BeforeEach(func() {
+			query, err = server.ParseStatisticsQuery("git", "", "2019-12-31", "2020-01-06", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should only contain the days in range", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Granularity).To(Equal(server.GranularityDay))
+			Expect(stats.Data).To(Equal(map[string]int64{
+				"2019-12-31": 2,
+				"2020-01-01": 3,
+				"2020-01-06": 4,
+			}))
+			Expect(stats.Total).To(Equal(int64(9)))
+		})
+	})
+
+	Context("by week", func() {
+		BeforeEach(func() {
+			query, err = server.ParseStatisticsQuery("git", "", "2019-01-01", "2020-12-31", server.GranularityWeek)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should use the Monday as the key", func() {
+			Expect(stats.Data).To(Equal(map[string]int64{
+				"2019-12-30": 6,
+				"2020-01-06": 4,
+				"2020-01-27": 5,
+			}))
+			Expect(stats.Total).To(Equal(int64(15)))
+		})
+	})
+
+	Context("by month of a provider", func() {
+		BeforeEach(func() {
+			query, err = server.ParseStatisticsQuery("git", "tsinghua", "2019-01-01", "2020-12-31", server.GranularityMonth)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should only count the provider", func() {
+			Expect(stats.Data).To(Equal(map[string]int64{
+				"2019-12": 1,
+				"2020-01": 7,
+				"2020-02": 5,
+			}))
+			Expect(stats.Total).To(Equal(int64(13)))
+		})
+	})
+
+	Context("without data in the range", func() {
+		BeforeEach(func() {
+			query, err = server.ParseStatisticsQuery("git", "", "2010-01-01", "2010-12-31", "")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should be empty", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.Data).To(BeEmpty())
+			Expect(stats.Total).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("ParseStatisticsQuery", func() {
+	It("default range", func() {
+		query, err := server.ParseStatisticsQuery("git", "", "", "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query.To.Format("2006-01-02")).To(Equal(server.GetDate()))
+		Expect(query.From.Format("2006-01-02")).To(Equal(server.GetCurrentYear() + "-01-01"))
+		Expect(query.Granularity).To(Equal(server.GranularityDay))
+	})
+
+	It("invalid inputs", func() {
+		_, err := server.ParseStatisticsQuery("git", "", "2020-13-01", "", "")
+		Expect(err).To(HaveOccurred())
+		_, err = server.ParseStatisticsQuery("git", "", "2020-02-01", "2020-01-01", "")
+		Expect(err).To(HaveOccurred())
+		_, err = server.ParseStatisticsQuery("git", "", "", "", "year")
+		Expect(err).To(HaveOccurred())
+		_, err = server.ParseStatisticsQuery("git", "", "0001-01-01", "9999-12-31", "")
+		Expect(err).To(MatchError(ContainSubstring("longer than 10 years")))
+	})
+})
+
+var _ = Describe("GetPeriod", func() {
+	It("week starts from Monday", func() {
+		sunday := time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC)
+		Expect(server.GetPeriod(sunday, server.GranularityWeek)).To(Equal("2019-12-30"))
+		Expect(server.GetPeriod(sunday.AddDate(0, 0, 1), server.GranularityWeek)).To(Equal("2020-01-06"))
+	})
+})
+
+var _ = Describe("RankPlugins", func() {
+	var (
+		dir     string
+		counter *server.GitPluginDownloadCounter
+		query   server.StatisticsQuery
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "ranking")
+		Expect(err).NotTo(HaveOccurred())
+
+		counter = &server.GitPluginDownloadCounter{Path: dir}
+		Expect(counter.SaveCounts([]server.DownloadCount{
+			{Plugin: "git", Date: "2019-12-31", Count: 4},
+			{Plugin: "git", Date: "2020-01-01", Count: 6},
+			{Plugin: "maven", Date: "2020-01-02", Count: 8},
+			{Plugin: "ant", Date: "2020-01-02", Count: 6},
+			{Plugin: "update-center", Date: "2020-01-02", Count: 100},
+		})).To(Succeed())
+
+		query, err = server.ParseStatisticsQuery("", "", "2020-01-01", "2020-01-02", "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("ranks by downloads", func() {
+		ranking := server.RankPlugins(counter, query, 10, 0, false)
+		Expect(ranking.Total).To(Equal(3))
+		Expect(ranking.Plugins).To(Equal([]server.PluginRank{
+			{Plugin: "maven", Downloads: 8},
+			{Plugin: "ant", Downloads: 6},
+			{Plugin: "git", Downloads: 6},
+		}))
+	})
+
+	It("with limit and offset", func() {
+		ranking := server.RankPlugins(counter, query, 1, 1, false)
+		Expect(ranking.Total).To(Equal(3))
+		Expect(ranking.Plugins).To(Equal([]server.PluginRank{{Plugin: "ant", Downloads: 6}}))
+
+		ranking = server.RankPlugins(counter, query, 1, 5, false)
+		Expect(ranking.Plugins).To(BeEmpty())
+	})
+
+	It("with growth", func() {
+		ranking := server.RankPlugins(counter, query, 10, 0, true)
+		Expect(ranking.PreviousFrom).To(Equal("2019-12-30"))
+		Expect(ranking.PreviousTo).To(Equal("2019-12-31"))
+
+		git := ranking.Plugins[2]
+		Expect(git.Plugin).To(Equal("git"))
+		Expect(*git.PreviousDownloads).To(Equal(int64(4)))
+		Expect(*git.Growth).To(Equal(0.5))
+
+		maven := ranking.Plugins[0]
+		Expect(*maven.PreviousDownloads).To(BeZero())
+		Expect(maven.Growth).To(BeNil())
+	})
+})
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// StatsOptions represents the options for showing and exporting the download data
+type StatsOptions struct {
+	Name        string
+	Provider    string
+	From        string
+	To          string
+	Granularity string
+	Limit       int
+
+	Format string
+	Output string
+}
+
+var statsOptions StatsOptions
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Manage the download data",
+}
+
+var statsShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the download counts of a plugin, or the top plugins if the name is not given",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = statsOptions.Show(cmd, &serverOptions)
+		return
+	},
+}
+
+var statsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the download counts of all plugins",
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = statsOptions.Export(cmd, &serverOptions)
+		return
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.AddCommand(statsShowCmd, statsExportCmd)
+
+	for _, cmd := range []*cobra.Command{statsShowCmd, statsExportCmd} {
+		cmd.Flags().StringVarP(&statsOptions.Provider, "provider", "", "",
+			"Only count the downloads from this provider")
+		cmd.Flags().StringVarP(&statsOptions.From, "from", "", "",
+			"The first date (2006-01-02) of the range, the first day of the year by default")
+		cmd.Flags().StringVarP(&statsOptions.To, "to", "", "",
+			"The last date (2006-01-02) of the range, today by default")
+	}
+
+	statsShowCmd.Flags().StringVarP(&statsOptions.Name, "name", "", "",
+		"The name of the plugin")
+	statsShowCmd.Flags().StringVarP(&statsOptions.Granularity, "granularity", "", GranularityDay,
+		"The granularity of the counts, supported: day, week, month")
+	statsShowCmd.Flags().IntVarP(&statsOptions.Limit, "limit", "", 10,
+		"The count of the top plugins")
+
+	statsExportCmd.Flags().StringVarP(&statsOptions.Format, "format", "", ExportFormatCSV,
+		"The format of the output, supported formats: csv, json, ndjson")
+	statsExportCmd.Flags().StringVarP(&statsOptions.Output, "output", "o", "",
+		"The output file, it is the stdout by default")
+}
+
+// Show prints the counts of a plugin, or the ranking of plugins
+func (s *StatsOptions) Show(cmd *cobra.Command, o *ServerOptions) (err error) {
+	var query StatisticsQuery
+	if query, err = ParseStatisticsQuery(s.Name, s.Provider, s.From, s.To, s.Granularity); err != nil {
+		return
+	}
+
+	var counter PluginDownloadCounter
+	var closeCounter func()
+	if counter, closeCounter, err = o.openPluginDownloadCounter(true); err != nil {
+		return
+	}
+	defer closeCounter()
+
+	if s.Name == "" {
+		ranking := RankPlugins(counter, query, s.Limit, 0, false)
+		for i, rank := range ranking.Plugins {
+			cmd.Printf("%d\t%s\t%d\n", i+1, rank.Plugin, rank.Downloads)
+		}
+		return
+	}
+
+	var stats PluginStatistics
+	if stats, err = QueryPluginStatistics(counter, query); err == nil {
+		for _, period := range sortedCountKeys(stats.Data) {
+			cmd.Printf("%s\t%d\n", period, stats.Data[period])
+		}
+		cmd.Printf("total\t%d\n", stats.Total)
+	}
+	return
+}
+
+// Export writes the counts of all plugins to the output file or stdout
+func (s *StatsOptions) Export(cmd *cobra.Command, o *ServerOptions) (err error) {
+	var query StatisticsQuery
+	if query, err = ParseStatisticsQuery("", s.Provider, s.From, s.To, ""); err != nil {
+		return
+	}
+
+	var counter PluginDownloadCounter
+	var closeCounter func()
+	if counter, closeCounter, err = o.openPluginDownloadCounter(true); err != nil {
+		return
+	}
+	defer closeCounter()
+
+	writer := cmd.OutOrStdout()
+	if s.Output != "" {
+		var file *os.File
+		if file, err = os.Create(s.Output); err != nil {
+			return
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		writer = file
+	}
+	return ExportDownloadCounts(writer, counter, query, s.Format)
+}
+
+// openPluginDownloadCounter creates the counter for the commands, it should be closed after using.
+// The read-only one could be opened while the server is running
+func (o *ServerOptions) openPluginDownloadCounter(readOnly bool) (counter PluginDownloadCounter, closeCounter func(), err error) {
+	if counter, err = newPluginDownloadCounter(o.CounterType, o.DataFilePath, readOnly); err != nil {
+		return
+	}
+
+	closeCounter = func() {}
+	if closer, ok := counter.(io.Closer); ok {
+		closeCounter = func() {
+			_ = closer.Close()
+		}
+	}
+	return
+}
@@ -0,0 +1,272 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var statsImportCmd = &cobra.Command{
+	Use:   "import <access log file>...",
+	Short: "Rebuild the download data from the access logs",
+	Long: `Rebuild the download data from the access logs of mirror-proxy (combined, common or JSON format),
+or the logs of Caddy. The counts of a plugin in a day which already exist will be skipped`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		err = serverOptions.ImportAccessLogFiles(cmd, args)
+		return
+	},
+}
+
+func init() {
+	statsCmd.AddCommand(statsImportCmd)
+}
+
+// ImportAccessLogFiles imports the access log files into the counter of the options
+func (o *ServerOptions) ImportAccessLogFiles(cmd *cobra.Command, args []string) (err error) {
+	var counter PluginDownloadCounter
+	var closeCounter func()
+	if counter, closeCounter, err = o.openPluginDownloadCounter(false); err != nil {
+		return
+	}
+	defer closeCounter()
+
+	readers := make([]io.Reader, 0, len(args))
+	for _, file := range args {
+		var reader *os.File
+		if reader, err = os.Open(file); err != nil {
+			return
+		}
+		defer func() {
+			_ = reader.Close()
+		}()
+		readers = append(readers, reader)
+	}
+
+	var result ImportResult
+	if result, err = ImportAccessLogs(counter, readers...); err == nil {
+		cmd.Printf("imported %d records, skipped %d duplicated records, ignored %d lines\n",
+			result.Imported, result.Skipped, result.Ignored)
+		for _, day := range result.SkippedDays {
+			cmd.Printf("skipped %s, it already exists\n", day)
+		}
+	}
+	return
+}
+
+// ImportResult is the summary of importing the access logs
+type ImportResult struct {
+	// Imported is the count of the requests which are counted
+	Imported int
+	// Skipped is the count of the requests whose plugin and date already exist
+	Skipped int
+	// SkippedDays are the plugin and date pairs which already exist, such as "git 2020-01-01"
+	SkippedDays []string
+	// Ignored is the count of the lines which cannot be parsed or are not the download requests
+	Ignored int
+}
+
+// ImportAccessLogs replays the download requests of the access logs into the counter
+func ImportAccessLogs(counter PluginDownloadCounter, readers ...io.Reader) (result ImportResult, err error) {
+	existing := map[string]*PluginDownloadData{}
+	skippedDays := map[string]bool{}
+	counts := map[DownloadCount]int64{}
+
+	for _, reader := range readers {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			entry, parseErr := ParseAccessLogLine(scanner.Text())
+			if parseErr != nil {
+				result.Ignored++
+				continue
+			}
+
+			count, ok := GetAccessLogDownloadCount(entry)
+			if !ok {
+				result.Ignored++
+				continue
+			}
+
+			year := count.Year()
+			if _, found := existing[year]; !found {
+				if existing[year], err = counter.FindByYear(year); os.IsNotExist(err) {
+					existing[year], err = &PluginDownloadData{}, nil
+				} else if err != nil {
+					err = fmt.Errorf("cannot read the download data of %s: %v", year, err)
+					return
+				}
+			}
+			if _, found := existing[year].Plugins[count.Plugin].Data[count.Date]; found {
+				// the partial counts of a day cannot be told from the complete ones
+				result.Skipped++
+				skippedDays[count.Plugin+" "+count.Date] = true
+				continue
+			}
+
+			counts[count]++
+			result.Imported++
+		}
+		if err = scanner.Err(); err != nil {
+			return
+		}
+	}
+
+	for day := range skippedDays {
+		result.SkippedDays = append(result.SkippedDays, day)
+	}
+	sort.Strings(result.SkippedDays)
+
+	batch := make([]DownloadCount, 0, len(counts))
+	for count, total := range counts {
+		count.Count = total
+		batch = append(batch, count)
+	}
+	if len(batch) > 0 {
+		err = counter.SaveCounts(batch)
+	}
+	return
+}
+
+var clfPattern = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+) ?(\S*)" (\d{3}) (\S+)` +
+	`(?: ("(?:[^"\\]|\\.)*") ("(?:[^"\\]|\\.)*"))?`)
+
+// ParseAccessLogLine parses a line in the Common/Combined Log Format, the JSON format of mirror-proxy or Caddy
+func ParseAccessLogLine(line string) (entry AccessLogEntry, err error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		return parseJSONAccessLog(line)
+	}
+
+	matches := clfPattern.FindStringSubmatch(line)
+	if matches == nil {
+		err = fmt.Errorf("unknown access log format: %s", line)
+		return
+	}
+
+	if entry.Time, err = time.Parse(clfTimeLayout, matches[2]); err != nil {
+		return
+	}
+	entry.RemoteIP, entry.Method, entry.URI, entry.Protocol = matches[1], matches[3], matches[4], matches[5]
+	entry.Status, _ = strconv.Atoi(matches[6])
+	entry.Size, _ = strconv.ParseInt(matches[7], 10, 64)
+	entry.Referer = unquoteLogField(matches[8])
+	entry.UserAgent = unquoteLogField(matches[9])
+	return
+}
+
+func unquoteLogField(field string) string {
+	if field == "" {
+		return ""
+	}
+	value, err := strconv.Unquote(field)
+	if err != nil {
+		value = strings.Trim(field, `"`)
+	}
+	if value == "-" {
+		value = ""
+	}
+	return value
+}
+
+// caddyAccessLog is the JSON access log of Caddy 2
+type caddyAccessLog struct {
+	Timestamp float64 `json:"ts"`
+	Status    int     `json:"status"`
+	Size      int64   `json:"size"`
+	Request   struct {
+		RemoteAddr string              `json:"remote_addr"`
+		Proto      string              `json:"proto"`
+		Method     string              `json:"method"`
+		URI        string              `json:"uri"`
+		Headers    map[string][]string `json:"headers"`
+	} `json:"request"`
+}
+
+func parseJSONAccessLog(line string) (entry AccessLogEntry, err error) {
+	var caddy caddyAccessLog
+	if err = json.Unmarshal([]byte(line), &caddy); err != nil {
+		return
+	}
+
+	if caddy.Request.URI == "" {
+		// the JSON format of mirror-proxy
+		if err = json.Unmarshal([]byte(line), &entry); err == nil && entry.URI == "" {
+			err = fmt.Errorf("unknown access log format: %s", line)
+		}
+		return
+	}
+
+	seconds, fraction := math.Modf(caddy.Timestamp)
+	entry = AccessLogEntry{
+		Time:     time.Unix(int64(seconds), int64(fraction*float64(time.Second))),
+		RemoteIP: caddy.Request.RemoteAddr,
+		Method:   caddy.Request.Method,
+		URI:      caddy.Request.URI,
+		Protocol: caddy.Request.Proto,
+		Status:   caddy.Status,
+		Size:     caddy.Size,
+	}
+	if host, _, splitErr := net.SplitHostPort(entry.RemoteIP); splitErr == nil {
+		entry.RemoteIP = host
+	}
+	if userAgents := caddy.Request.Headers["User-Agent"]; len(userAgents) > 0 {
+		entry.UserAgent = userAgents[0]
+	}
+	if referers := caddy.Request.Headers["Referer"]; len(referers) > 0 {
+		entry.Referer = referers[0]
+	}
+	return
+}
+
+// GetAccessLogDownloadCount returns the count of a plugin download or an update center visit,
+// it's the same as the one counted by HandlePluginDownload or HandleUpdateCenter
+func GetAccessLogDownloadCount(entry AccessLogEntry) (count DownloadCount, ok bool) {
+	if entry.Method != "GET" && entry.Method != "HEAD" {
+		return
+	}
+
+	requestURL, err := url.ParseRequestURI(entry.URI)
+	if err != nil {
+		return
+	}
+	query := requestURL.Query()
+
+	count = DownloadCount{
+		Date:  entry.Time.Format(dateLayout),
+		Count: 1,
+	}
+	switch {
+	case strings.HasPrefix(requestURL.EscapedPath(), "/jenkins/plugins/"):
+		uri := requestURL.EscapedPath()
+		count.Plugin = strings.Split(uri[strings.LastIndex(uri, "/")+1:], ".")[0]
+		count.Provider = query.Get("provider")
+		count.Version = query.Get("version")
+		if count.Version == "" {
+			count.Version = getJenkinsVersionFromUserAgent(entry.UserAgent)
+		}
+	case requestURL.Path == "/update-center.json":
+		count.Plugin = "update-center"
+		count.Provider = query.Get("mirror-provider")
+		count.Version = query.Get("version")
+	default:
+		return
+	}
+	if !IsJenkinsVersion(count.Version) {
+		count.Version = ""
+	}
+	ok = IsPluginName(count.Plugin)
+	return
+}
@@ -0,0 +1,131 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const accessLogs = `1.2.3.4 - - [01/Jan/2020:10:00:00 +0000] "GET /jenkins/plugins/git/4.4.5/git.hpi?provider=tsinghua HTTP/1.1" 301 - "-" "Jenkins/2.263.1"
+1.2.3.4 - - [01/Jan/2020:11:00:00 +0000] "GET /jenkins/plugins/git/4.4.5/git.hpi HTTP/1.1" 301 -
+1.2.3.4 - - [02/Jan/2020:10:00:00 +0000] "GET /update-center.json?version=2.263.1&mirror-provider=tsinghua HTTP/1.1" 301 0 "-" "curl/7.0"
+1.2.3.4 - - [02/Jan/2020:10:00:00 +0000] "GET /status HTTP/1.1" 200 2 "-" "curl/7.0"
+not a log line
+{"time":"2020-01-03T10:00:00Z","remote_ip":"1.2.3.4","method":"GET","uri":"/jenkins/plugins/maven/1.0/maven.hpi","status":301}
+{"ts":1578132000.5,"request":{"remote_addr":"1.2.3.4:1234","proto":"HTTP/2.0","method":"GET","uri":"/jenkins/plugins/ant/1.0/ant.hpi","headers":{"User-Agent":["Jenkins/2.222.1"]}},"status":301,"size":0}
+`
+
+var _ = Describe("ParseAccessLogLine", func() {
+	It("combined", func() {
+		entry, err := server.ParseAccessLogLine(`1.2.3.4 - - [01/Jan/2020:10:00:00 +0800] "GET /update-center.json HTTP/1.1" 301 12 "https://a.com" "Jenkins/2.263.1 \"x\""`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entry.RemoteIP).To(Equal("1.2.3.4"))
+		Expect(entry.Time.Format("2006-01-02 15:04 -0700")).To(Equal("2020-01-01 10:00 +0800"))
+		Expect(entry.URI).To(Equal("/update-center.json"))
+		Expect(entry.Status).To(Equal(301))
+		Expect(entry.Size).To(Equal(int64(12)))
+		Expect(entry.Referer).To(Equal("https://a.com"))
+		Expect(entry.UserAgent).To(Equal(`Jenkins/2.263.1 "x"`))
+	})
+
+	It("the format of the access log", func() {
+		for _, format := range []string{server.AccessLogFormatCommon, server.AccessLogFormatCombined, server.AccessLogFormatJSON} {
+			line := server.FormatAccessLog(server.AccessLogEntry{
+				RemoteIP: "1.2.3.4", Method: "GET", URI: "/update-center.json", Protocol: "HTTP/1.1", Status: 301,
+				UserAgent: "Jenkins/2.263.1",
+			}, format)
+
+			entry, err := server.ParseAccessLogLine(string(line))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.URI).To(Equal("/update-center.json"))
+			Expect(entry.Status).To(Equal(301))
+		}
+	})
+
+	It("invalid lines", func() {
+		_, err := server.ParseAccessLogLine("fake")
+		Expect(err).To(HaveOccurred())
+		_, err = server.ParseAccessLogLine(`{"level":"info"}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ImportAccessLogs", func() {
+	var (
+		dir     string
+		counter *server.GitPluginDownloadCounter
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "import")
+		Expect(err).NotTo(HaveOccurred())
+		counter = &server.GitPluginDownloadCounter{Path: dir}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("import the download requests", func() {
+		result, err := server.ImportAccessLogs(counter, strings.NewReader(accessLogs))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(server.ImportResult{Imported: 5, Ignored: 2}))
+
+		data, err := counter.FindPluginData("2020", "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data).To(Equal(map[string]int64{"2020-01-01": 2}))
+		Expect(data.Providers["tsinghua"]).To(Equal(map[string]int64{"2020-01-01": 1}))
+		Expect(data.Versions["2.263.1"]).To(Equal(map[string]int64{"2020-01-01": 1}))
+
+		data, err = counter.FindPluginData("2020", "update-center")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Providers["tsinghua"]).To(Equal(map[string]int64{"2020-01-02": 1}))
+
+		data, err = counter.FindPluginData("2020", "ant")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Versions["2.222.1"]).To(Equal(map[string]int64{"2020-01-04": 1}))
+	})
+
+	It("skip the existing counts", func() {
+		Expect(counter.SaveCounts([]server.DownloadCount{{Plugin: "git", Date: "2020-01-01", Count: 7}})).To(Succeed())
+
+		result, err := server.ImportAccessLogs(counter, strings.NewReader(accessLogs))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(server.ImportResult{Imported: 3, Skipped: 2, Ignored: 2,
+			SkippedDays: []string{"git 2020-01-01"}}))
+
+		result, err = server.ImportAccessLogs(counter, strings.NewReader(accessLogs))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Skipped).To(Equal(5))
+		Expect(result.SkippedDays).To(ContainElement("git 2020-01-01"))
+
+		data, err := counter.FindPluginData("2020", "git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data.Data).To(Equal(map[string]int64{"2020-01-01": 7}))
+	})
+
+	It("the data cannot be read", func() {
+		Expect(ioutil.WriteFile(counter.GetDataFilePath("2020"), []byte("plugins: [broken"), 0644)).To(Succeed())
+
+		_, err := server.ImportAccessLogs(counter, strings.NewReader(accessLogs))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("stats import command", func() {
+		logFile := path.Join(dir, "access.log")
+		Expect(ioutil.WriteFile(logFile, []byte(accessLogs), 0644)).To(Succeed())
+
+		buf := &bytes.Buffer{}
+		rootCmd := server.GetRootCmd()
+		rootCmd.SetOutput(buf)
+		rootCmd.SetArgs([]string{"stats", "import", logFile, "--data-file-path", path.Join(dir, "data")})
+		Expect(rootCmd.Execute()).To(Succeed())
+		Expect(buf.String()).To(Equal("imported 5 records, skipped 0 duplicated records, ignored 2 lines\n"))
+	})
+})
@@ -0,0 +1,229 @@
+package pkg_test
+
+import (
+	"bytes"
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+var _ = Describe("subcommands", func() {
+	var (
+		buf *bytes.Buffer
+		err error
+	)
+
+	execute := func(args ...string) error {
+		rootCmd := server.GetRootCmd()
+		rootCmd.SetOutput(buf)
+		rootCmd.SetArgs(args)
+		return rootCmd.Execute()
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+	})
+
+	Context("cache", func() {
+		AfterEach(func() {
+			Expect(os.RemoveAll("cache.yaml")).To(Succeed())
+		})
+
+		It("list and clear", func() {
+			cacheServer := &server.FileSystemCacheServer{FileName: "cache.yaml"}
+			Expect(cacheServer.Save("", "https://a.com/update-center.json")).To(Succeed())
+			Expect(cacheServer.Save("2.263.1", "https://b.com/update-center.json")).To(Succeed())
+
+			Expect(execute("cache", "list")).To(Succeed())
+			Expect(buf.String()).To(Equal("latest\thttps://a.com/update-center.json\n" +
+				"2.263.1\thttps://b.com/update-center.json\n"))
+
+			Expect(execute("cache", "clear", "latest")).To(Succeed())
+			Expect(cacheServer.List()).To(Equal(map[string]string{"2.263.1": "https://b.com/update-center.json"}))
+		})
+
+		It("use the given cache file and Redis", func() {
+			defer func() {
+				flags := server.GetRootCmd().PersistentFlags()
+				for _, name := range []string{"cache-type", "cache-file", "cache-redis-address"} {
+					Expect(flags.Set(name, flags.Lookup(name).DefValue)).To(Succeed())
+					flags.Lookup(name).Changed = false
+				}
+			}()
+
+			cacheServer := &server.FileSystemCacheServer{FileName: "cache.yaml"}
+			Expect(cacheServer.Save("2.263.1", "https://b.com/update-center.json")).To(Succeed())
+
+			Expect(execute("cache", "list", "--cache-file", "other.yaml")).To(Succeed())
+			Expect(buf.String()).To(BeEmpty())
+
+			fake := newFakeRedisServer("")
+			defer fake.Close()
+			redisCache := &server.RedisCacheServer{Address: fake.Address(), KeyPrefix: "mirror-proxy:cache:"}
+			defer func() {
+				_ = redisCache.Close()
+			}()
+			Expect(redisCache.Save("2.264", "https://c.com/update-center.json")).To(Succeed())
+
+			Expect(execute("cache", "list", "--cache-type", "redis", "--cache-redis-address", fake.Address())).To(Succeed())
+			Expect(buf.String()).To(Equal("2.264\thttps://c.com/update-center.json\n"))
+		})
+	})
+
+	Context("stats", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, err = ioutil.TempDir("", "stats")
+			Expect(err).NotTo(HaveOccurred())
+
+			counter := &server.GitPluginDownloadCounter{Path: dir}
+			Expect(counter.SaveCounts([]server.DownloadCount{
+				{Plugin: "git", Date: "2020-01-01", Count: 2},
+				{Plugin: "git", Date: "2020-01-02", Count: 3},
+				{Plugin: "maven", Date: "2020-01-01", Count: 1},
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("show a plugin", func() {
+			Expect(execute("stats", "show", "--data-file-path", dir, "--name", "git",
+				"--from", "2020-01-01", "--to", "2020-01-31", "--granularity", "month")).To(Succeed())
+			Expect(buf.String()).To(Equal("2020-01\t5\ntotal\t5\n"))
+		})
+
+		It("show the top plugins", func() {
+			Expect(execute("stats", "show", "--data-file-path", dir, "--name", "",
+				"--from", "2020-01-01", "--to", "2020-01-31", "--granularity", "day")).To(Succeed())
+			Expect(buf.String()).To(Equal("1\tgit\t5\n2\tmaven\t1\n"))
+		})
+
+		It("export to a file", func() {
+			output := path.Join(dir, "export.csv")
+			Expect(execute("stats", "export", "--data-file-path", dir, "--from", "2020-01-01", "--to", "2020-01-31",
+				"--output", output)).To(Succeed())
+			Expect(ioutil.ReadFile(output)).To(Equal([]byte("plugin,date,count\n" +
+				"git,2020-01-01,2\ngit,2020-01-02,3\nmaven,2020-01-01,1\n")))
+		})
+	})
+
+	Context("config", func() {
+		AfterEach(func() {
+			viper.Reset()
+		})
+
+		It("validate", func() {
+			Expect(execute("config", "validate")).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("the config is valid"))
+		})
+
+		It("validate an invalid config", func() {
+			viper.Set("jsonServers", map[string]string{"fake": "not a url"})
+			Expect(execute("config", "validate")).NotTo(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`the URL of JSON server fake is invalid: "not a url"`))
+		})
+
+		It("print", func() {
+			viper.Set("providers", []string{"tsinghua"})
+			Expect(execute("config", "print")).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("providers:\n- tsinghua\n"))
+		})
+
+		It("print without the secrets", func() {
+			viper.Set("git-password", "secret-token")
+			viper.Set("cache-redis-password", "secret-password")
+			Expect(execute("config", "print")).To(Succeed())
+			Expect(buf.String()).NotTo(ContainSubstring("secret"))
+			Expect(buf.String()).To(ContainSubstring("git-password: '******'"))
+		})
+	})
+
+	Context("config binding", func() {
+		var (
+			dir        string
+			configFile string
+		)
+
+		BeforeEach(func() {
+			dir, err = ioutil.TempDir("", "config")
+			Expect(err).To(BeNil())
+			configFile = path.Join(dir, "mirror-proxy.yaml")
+			Expect(ioutil.WriteFile(configFile, []byte(`default-provider: huawei
+defaultJSONServer: gitee
+jsonServers:
+  Gitee: https://jenkins-zh.gitee.io/update-center-mirror
+cert: server.crt
+key: server.key
+flush-interval: 5m
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+			Expect(os.Unsetenv("MIRROR_PROXY_DEFAULT_PROVIDER")).To(Succeed())
+			viper.Reset()
+
+			// the flags keep the values between the executions
+			server.GetRootCmd().PersistentFlags().VisitAll(func(flag *pflag.Flag) {
+				Expect(flag.Value.Set(flag.DefValue)).To(Succeed())
+				flag.Changed = false
+			})
+		})
+
+		It("read from the config file", func() {
+			Expect(execute("config", "validate", "--config", configFile)).To(Succeed())
+
+			options := server.GetServerOptions()
+			Expect(options.DefaultProvider).To(Equal("huawei"))
+			Expect(options.CertFile).To(Equal("server.crt"))
+			Expect(options.KeyFile).To(Equal("server.key"))
+			Expect(options.FlushInterval.String()).To(Equal("5m0s"))
+			Expect(options.DefaultJSONServer).To(Equal("gitee"))
+			Expect(options.GetDefaultJSONServer()).To(Equal("https://jenkins-zh.gitee.io/update-center-mirror"))
+		})
+
+		It("the env overrides the config file", func() {
+			Expect(os.Setenv("MIRROR_PROXY_DEFAULT_PROVIDER", "aliyun")).To(Succeed())
+			Expect(execute("config", "validate", "--config", configFile)).To(Succeed())
+			Expect(server.GetServerOptions().DefaultProvider).To(Equal("aliyun"))
+		})
+
+		It("the flag overrides the env", func() {
+			Expect(os.Setenv("MIRROR_PROXY_DEFAULT_PROVIDER", "aliyun")).To(Succeed())
+			Expect(execute("config", "validate", "--config", configFile, "--default-provider", "tencent")).To(Succeed())
+			Expect(server.GetServerOptions().DefaultProvider).To(Equal("tencent"))
+		})
+
+		It("report the unknown keys", func() {
+			Expect(ioutil.WriteFile(configFile, []byte("default-providers: huawei\n"), 0644)).To(Succeed())
+			Expect(execute("config", "validate", "--config", configFile)).NotTo(Succeed())
+			Expect(buf.String()).To(ContainSubstring("unknown key in the config: default-providers"))
+		})
+
+		It("rewrite the update center without the external URL", func() {
+			Expect(execute("config", "validate", "--rewrite-update-center")).NotTo(Succeed())
+			Expect(buf.String()).To(ContainSubstring("the external-url is required to rewrite the update center"))
+		})
+
+		It("the default provider is not in the providers", func() {
+			Expect(ioutil.WriteFile(configFile, []byte("default-provider: huawei\nproviders:\n- tsinghua\n"), 0644)).To(Succeed())
+			Expect(execute("config", "validate", "--config", configFile)).NotTo(Succeed())
+			Expect(buf.String()).To(ContainSubstring("the default provider huawei is not in the providers"))
+
+			Expect(execute("--config", configFile)).To(MatchError("the default provider huawei is not in the providers"))
+		})
+
+		It("invalid value", func() {
+			Expect(ioutil.WriteFile(configFile, []byte("port: abc\n"), 0644)).To(Succeed())
+			Expect(execute("config", "validate", "--config", configFile)).To(MatchError(ContainSubstring("invalid value of port")))
+		})
+	})
+})
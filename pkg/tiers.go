@@ -0,0 +1,262 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TierManifest is the tiers of the Jenkins versions, it's the same as https://updates.jenkins.io/tiers.json
+type TierManifest struct {
+	StableCores []string `json:"stableCores"`
+	WeeklyCores []string `json:"weeklyCores"`
+}
+
+// ResolveTier returns the directory of the update center which a Jenkins version belongs to,
+// such as current, dynamic-2.270 or dynamic-stable-2.263.1. It's not ok if the manifest does not cover the version
+func (m *TierManifest) ResolveTier(version string) (tier string, ok bool) {
+	if version == "" {
+		return "current", true
+	}
+
+	parts, err := parseVersion(version)
+	if err != nil {
+		return
+	}
+
+	switch len(parts) {
+	case 3:
+		// the LTS versions of the same baseline, such as 2.263.1 and 2.263.2
+		var line [][]int
+		for _, core := range m.StableCores {
+			if coreParts, parseErr := parseVersion(core); parseErr == nil && len(coreParts) == 3 &&
+				coreParts[0] == parts[0] && coreParts[1] == parts[1] {
+				line = append(line, coreParts)
+			}
+		}
+		if core, found := pickTierVersion(line, parts); found {
+			tier, ok = "dynamic-stable-"+formatVersion(core), true
+		}
+	case 2:
+		var weekly [][]int
+		for _, core := range m.WeeklyCores {
+			if coreParts, parseErr := parseVersion(core); parseErr == nil && len(coreParts) == 2 {
+				weekly = append(weekly, coreParts)
+			}
+		}
+		if len(weekly) == 0 {
+			return
+		}
+
+		newer := true
+		for _, core := range weekly {
+			if compareVersions(core, parts) >= 0 {
+				newer = false
+			}
+		}
+		if newer {
+			return "current", true
+		}
+		if core, found := pickTierVersion(weekly, parts); found {
+			tier, ok = "dynamic-"+formatVersion(core), true
+		}
+	}
+	return
+}
+
+// pickTierVersion returns the newest version which is not newer than the given one, or the oldest version
+func pickTierVersion(versions [][]int, version []int) (picked []int, ok bool) {
+	for _, candidate := range versions {
+		if compareVersions(candidate, version) > 0 {
+			continue
+		}
+		if !ok || compareVersions(candidate, picked) > 0 {
+			picked, ok = candidate, true
+		}
+	}
+	if ok {
+		return
+	}
+
+	for _, candidate := range versions {
+		if !ok || compareVersions(candidate, picked) < 0 {
+			picked, ok = candidate, true
+		}
+	}
+	return
+}
+
+func parseVersion(version string) (parts []int, err error) {
+	for _, part := range strings.Split(version, ".") {
+		var number int
+		if number, err = strconv.Atoi(part); err != nil {
+			return
+		}
+		parts = append(parts, number)
+	}
+	return
+}
+
+func formatVersion(parts []int) string {
+	items := make([]string, len(parts))
+	for i, part := range parts {
+		items[i] = strconv.Itoa(part)
+	}
+	return strings.Join(items, ".")
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
+}
+
+// TierResolver resolves the update center URLs by the tier manifest, it works when the official site is unreachable
+type TierResolver struct {
+	// Source is a local file or a URL of the tier manifest, such as the one of the JSON server
+	Source string
+	// Interval is the interval of loading the manifest again, disabled if it's zero
+	Interval time.Duration
+	// Client fetches the manifest from a URL, a client with DefaultFetchTimeout is used if it's nil
+	Client *http.Client
+
+	lock     sync.RWMutex
+	manifest *TierManifest
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewTierResolver creates a resolver
+func NewTierResolver(source string, interval time.Duration) *TierResolver {
+	return &TierResolver{
+		Source:   source,
+		Interval: interval,
+	}
+}
+
+// Load reads the manifest from the source, the current one is kept if it fails
+func (r *TierResolver) Load() (err error) {
+	return r.load(context.Background())
+}
+
+func (r *TierResolver) load(ctx context.Context) (err error) {
+	var data []byte
+	if strings.HasPrefix(r.Source, "http://") || strings.HasPrefix(r.Source, "https://") {
+		client := r.Client
+		if client == nil {
+			client = fetchClient
+		}
+		data, err = fetchTierManifest(ctx, client, r.Source)
+	} else {
+		data, err = ioutil.ReadFile(r.Source)
+	}
+	if err != nil {
+		return
+	}
+
+	manifest := &TierManifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return
+	}
+	if len(manifest.StableCores) == 0 && len(manifest.WeeklyCores) == 0 {
+		return fmt.Errorf("no versions in the tier manifest %s", r.Source)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.manifest = manifest
+	return
+}
+
+// Start loads the manifest in the background, then loads it again every Interval until Stop is called.
+// The manifest is missing before the first load is done
+func (r *TierResolver) Start() {
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		reload := func() {
+			if err := r.load(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn("cannot load the tier manifest", zap.String("source", r.Source), zap.Error(err))
+			}
+		}
+
+		reload()
+		if r.Interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops loading the manifest, the loading in progress is canceled
+func (r *TierResolver) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+		r.cancel = nil
+	}
+}
+
+// Resolve returns the update center URL of a Jenkins version in the upstream,
+// it's not ok if the manifest is missing or does not cover the version
+func (r *TierResolver) Resolve(upstream, version string) (targetURL *url.URL, ok bool) {
+	r.lock.RLock()
+	manifest := r.manifest
+	r.lock.RUnlock()
+	if manifest == nil {
+		return
+	}
+
+	var tier string
+	if tier, ok = manifest.ResolveTier(version); ok {
+		var err error
+		targetURL, err = url.Parse(fmt.Sprintf("%s/%s/update-center.json", strings.TrimSuffix(upstream, "/"), tier))
+		ok = err == nil
+	}
+	return
+}
+
+func fetchTierManifest(ctx context.Context, client *http.Client, manifestURL string) (data []byte, err error) {
+	var request *http.Request
+	if request, err = http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil); err != nil {
+		return
+	}
+
+	var response *http.Response
+	if response, err = client.Do(request); err != nil {
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot fetch %s, status code: %d", manifestURL, response.StatusCode)
+		return
+	}
+	return ioutil.ReadAll(response.Body)
+}
@@ -0,0 +1,191 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const tierManifest = `{
+  "stableCores": ["2.249.1", "2.249.3", "2.263.1", "2.263.2"],
+  "weeklyCores": ["2.260", "2.265", "2.270"]
+}`
+
+var _ = Describe("TierManifest", func() {
+	manifest := &server.TierManifest{
+		StableCores: []string{"2.249.1", "2.249.3", "2.263.1", "2.263.2"},
+		WeeklyCores: []string{"2.260", "2.265", "2.270"},
+	}
+
+	DescribeTable("resolve the tier",
+		func(version, tier string, ok bool) {
+			result, resolved := manifest.ResolveTier(version)
+			Expect(resolved).To(Equal(ok))
+			Expect(result).To(Equal(tier))
+		},
+		Entry("the latest version", "", "current", true),
+		Entry("a stable core", "2.263.1", "dynamic-stable-2.263.1", true),
+		Entry("between the stable cores", "2.249.2", "dynamic-stable-2.249.1", true),
+		Entry("newer than the stable cores", "2.263.4", "dynamic-stable-2.263.2", true),
+		Entry("an unknown LTS line", "2.277.1", "", false),
+		Entry("a weekly core", "2.265", "dynamic-2.265", true),
+		Entry("between the weekly cores", "2.268", "dynamic-2.265", true),
+		Entry("older than the weekly cores", "2.250", "dynamic-2.260", true),
+		Entry("newer than the weekly cores", "2.271", "current", true),
+		Entry("an invalid version", "2.x", "", false),
+	)
+
+	It("empty manifest", func() {
+		_, ok := (&server.TierManifest{}).ResolveTier("2.265")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("TierResolver", func() {
+	var (
+		dir      string
+		file     string
+		resolver *server.TierResolver
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tiers")
+		Expect(err).NotTo(HaveOccurred())
+		file = filepath.Join(dir, "tiers.json")
+		Expect(ioutil.WriteFile(file, []byte(tierManifest), 0644)).To(Succeed())
+		resolver = server.NewTierResolver(file, 0)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("not ok without the manifest", func() {
+		_, ok := resolver.Resolve(server.DefaultUpstream, "2.263.1")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("load from a file", func() {
+		Expect(resolver.Load()).To(Succeed())
+		targetURL, ok := resolver.Resolve(server.DefaultUpstream+"/", "2.263.1")
+		Expect(ok).To(BeTrue())
+		Expect(targetURL.String()).To(Equal("https://updates.jenkins.io/dynamic-stable-2.263.1/update-center.json"))
+	})
+
+	It("load from a URL", func() {
+		jsonServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(tierManifest))
+		}))
+		defer jsonServer.Close()
+
+		resolver.Source = jsonServer.URL + "/tiers.json"
+		Expect(resolver.Load()).To(Succeed())
+		targetURL, ok := resolver.Resolve(server.DefaultUpstream, "2.268")
+		Expect(ok).To(BeTrue())
+		Expect(targetURL.String()).To(Equal("https://updates.jenkins.io/dynamic-2.265/update-center.json"))
+	})
+
+	It("keep the current manifest when the new one is invalid", func() {
+		Expect(resolver.Load()).To(Succeed())
+		Expect(ioutil.WriteFile(file, []byte("{}"), 0644)).To(Succeed())
+		Expect(resolver.Load()).To(MatchError(ContainSubstring("no versions in the tier manifest")))
+		Expect(ioutil.WriteFile(file, []byte("broken"), 0644)).To(Succeed())
+		Expect(resolver.Load()).NotTo(Succeed())
+
+		_, ok := resolver.Resolve(server.DefaultUpstream, "2.263.1")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("load the manifest periodically", func() {
+		Expect(ioutil.WriteFile(file, []byte("{}"), 0644)).To(Succeed())
+		resolver.Interval = 10 * time.Millisecond
+		resolver.Start()
+		defer resolver.Stop()
+
+		Expect(ioutil.WriteFile(file, []byte(tierManifest), 0644)).To(Succeed())
+		Eventually(func() bool {
+			_, ok := resolver.Resolve(server.DefaultUpstream, "2.263.1")
+			return ok
+		}).Should(BeTrue())
+	})
+
+	It("give up the stalled manifest", func() {
+		stalled := make(chan struct{})
+		jsonServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-stalled
+		}))
+		defer jsonServer.Close()
+		defer close(stalled)
+
+		resolver.Source = jsonServer.URL + "/tiers.json"
+		resolver.Client = &http.Client{Timeout: 100 * time.Millisecond}
+		Expect(resolver.Load()).NotTo(Succeed())
+	})
+
+	It("load in the background", func() {
+		stalled := make(chan struct{})
+		jsonServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-stalled
+			_, _ = w.Write([]byte(tierManifest))
+		}))
+		defer jsonServer.Close()
+		defer close(stalled)
+
+		resolver.Source = jsonServer.URL + "/tiers.json"
+		resolver.Start()
+		_, ok := resolver.Resolve(server.DefaultUpstream, "2.263.1")
+		Expect(ok).To(BeFalse())
+
+		By("cancel the loading when it stops")
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			resolver.Stop()
+		}()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("load once without the interval", func() {
+		resolver.Start()
+		defer resolver.Stop()
+		Eventually(func() bool {
+			_, ok := resolver.Resolve(server.DefaultUpstream, "2.263.1")
+			return ok
+		}).Should(BeTrue())
+	})
+
+	It("resolve without the upstream", func() {
+		var hits int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Location", "https://updates.jenkins.io/current/update-center.json")
+			w.WriteHeader(http.StatusFound)
+		}))
+		defer upstream.Close()
+
+		cacheServer, err := server.NewMemoryCacheServer(time.Hour, nil)
+		Expect(err).NotTo(HaveOccurred())
+		opt := &server.ServerOptions{Upstream: upstream.URL, Cache: cacheServer, Tiers: resolver}
+		Expect(resolver.Load()).To(Succeed())
+
+		targetURL, err := opt.GetAndCacheURL(server.UpdateCenterQuery{Version: "2.263.2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetURL.String()).To(Equal(upstream.URL + "/dynamic-stable-2.263.2/update-center.json"))
+		Expect(atomic.LoadInt32(&hits)).To(BeZero())
+
+		By("fallback to the upstream when the manifest does not cover the version")
+		targetURL, err = opt.GetAndCacheURL(server.UpdateCenterQuery{Version: "2.277.1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetURL.String()).To(Equal("https://updates.jenkins.io/current/update-center.json"))
+		Expect(atomic.LoadInt32(&hits)).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OfficialUpdateCenterURL is the URL of the latest official update center
+const OfficialUpdateCenterURL = "https://updates.jenkins.io/update-center.json"
+
+// DefaultUpstream is the official update center which resolves the update center URL of a Jenkins version
+const DefaultUpstream = "https://updates.jenkins.io"
+
+// DefaultFetchTimeout is the timeout of fetching the update center and the other metadata
+const DefaultFetchTimeout = time.Minute
+
+// fetchClient never waits for a stalled server forever
+var fetchClient = &http.Client{Timeout: DefaultFetchTimeout}
+
+const (
+	updateCenterPrefix = "updateCenter.post("
+	updateCenterSuffix = ");"
+)
+
+// UpdateCenter represents the update-center.json, only contains the fields we need
+type UpdateCenter struct {
+	Core    UpdateCenterItem            `json:"core"`
+	Plugins map[string]UpdateCenterItem `json:"plugins"`
+}
+
+// UpdateCenterItem represents the core or a plugin in the update center
+type UpdateCenterItem struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	URL     string `json:"url"`
+	Sha256  string `json:"sha256"`
+}
+
+// TrimUpdateCenterJSONP returns the JSON from the JSONP format (updateCenter.post(...);)
+func TrimUpdateCenterJSONP(data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte(updateCenterPrefix)) {
+		data = bytes.TrimPrefix(data, []byte(updateCenterPrefix))
+		data = bytes.TrimSuffix(data, []byte(updateCenterSuffix))
+		data = bytes.TrimSuffix(data, []byte(")"))
+	}
+	return bytes.TrimSpace(data)
+}
+
+// FetchUpdateCenterData returns the JSON data of an update center
+func FetchUpdateCenterData(updateCenterURL string) (data []byte, err error) {
+	return fetchUpdateCenterData(fetchClient, updateCenterURL)
+}
+
+func fetchUpdateCenterData(client *http.Client, updateCenterURL string) (data []byte, err error) {
+	var response *http.Response
+	if response, err = client.Get(updateCenterURL); err != nil {
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot fetch %s, status code: %d", updateCenterURL, response.StatusCode)
+		return
+	}
+
+	if data, err = ioutil.ReadAll(response.Body); err == nil {
+		data = TrimUpdateCenterJSONP(data)
+	}
+	return
+}
+
+// FetchUpdateCenter returns the update center from a URL
+func FetchUpdateCenter(updateCenterURL string) (updateCenter *UpdateCenter, err error) {
+	return fetchUpdateCenter(fetchClient, updateCenterURL)
+}
+
+func fetchUpdateCenter(client *http.Client, updateCenterURL string) (updateCenter *UpdateCenter, err error) {
+	var data []byte
+	if data, err = fetchUpdateCenterData(client, updateCenterURL); err == nil {
+		updateCenter = &UpdateCenter{}
+		err = json.Unmarshal(data, updateCenter)
+	}
+	return
+}
+
+// WrapUpdateCenterJSONP returns the JSONP format (updateCenter.post(...);) which Jenkins expects
+func WrapUpdateCenterJSONP(data []byte) []byte {
+	buf := bytes.NewBufferString(updateCenterPrefix + "\n")
+	buf.Write(bytes.TrimSpace(data))
+	buf.WriteString("\n" + updateCenterSuffix + "\n")
+	return buf.Bytes()
+}
+
+// RewriteUpdateCenter rewrites the download URLs of the core and plugins, let them go through the given base URL.
+// The provider will be kept as a query of the download URLs if it's not empty
+func RewriteUpdateCenter(data []byte, baseURL, provider string) (result []byte, err error) {
+	updateCenter := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&updateCenter); err != nil {
+		return
+	}
+
+	if core, ok := updateCenter["core"].(map[string]interface{}); ok {
+		rewriteItemURL(core, "war", baseURL, provider)
+	}
+	if plugins, ok := updateCenter["plugins"].(map[string]interface{}); ok {
+		for _, plugin := range plugins {
+			if item, ok := plugin.(map[string]interface{}); ok {
+				rewriteItemURL(item, "plugins", baseURL, provider)
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(updateCenter); err == nil {
+		result = buf.Bytes()
+	}
+	return
+}
+
+// rewriteItemURL changes the URL to {baseURL}/jenkins/{kind}/..., kind is plugins or war
+func rewriteItemURL(item map[string]interface{}, kind, baseURL, provider string) {
+	rawURL, _ := item["url"].(string)
+	if rawURL == "" {
+		return
+	}
+
+	var artifactPath string
+	if index := strings.Index(rawURL, "/"+kind+"/"); index >= 0 {
+		artifactPath = rawURL[index+1:]
+	} else if name, version := item["name"], item["version"]; kind == "plugins" && name != nil && version != nil {
+		artifactPath = fmt.Sprintf("plugins/%v/%v/%s", name, version, rawURL[strings.LastIndex(rawURL, "/")+1:])
+	} else {
+		return
+	}
+
+	targetURL := fmt.Sprintf("%s/jenkins/%s", strings.TrimSuffix(baseURL, "/"), artifactPath)
+	if provider != "" {
+		targetURL = fmt.Sprintf("%s?provider=%s", targetURL, url.QueryEscape(provider))
+	}
+	item["url"] = targetURL
+}
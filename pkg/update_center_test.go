@@ -0,0 +1,79 @@
+package pkg_test
+
+import (
+	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TrimUpdateCenterJSONP", func() {
+	It("with JSONP", func() {
+		Expect(string(server.TrimUpdateCenterJSONP([]byte("updateCenter.post(\n{}\n);\n")))).To(Equal("{}"))
+		Expect(string(server.TrimUpdateCenterJSONP([]byte("updateCenter.post({})")))).To(Equal("{}"))
+	})
+
+	It("with JSON", func() {
+		Expect(string(server.TrimUpdateCenterJSONP([]byte(" {} ")))).To(Equal("{}"))
+	})
+})
+
+var _ = Describe("RewriteUpdateCenter", func() {
+	var (
+		data     string
+		provider string
+		result   []byte
+		err      error
+	)
+
+	BeforeEach(func() {
+		provider = ""
+		data = `{"core":{"name":"core","version":"2.263","url":"https://updates.jenkins.io/download/war/2.263/jenkins.war"},
+"plugins":{"git":{"name":"git","version":"4.4.5","url":"https://mirrors.tuna.tsinghua.edu.cn/jenkins/plugins/git/4.4.5/git.hpi",
+"size":1234567890123},
+"ant":{"name":"ant","version":"1.11","url":"https://example.com/ant.hpi"}},
+"id":"default","signature":{"digest":"a&b"}}`
+	})
+
+	JustBeforeEach(func() {
+		result, err = server.RewriteUpdateCenter([]byte(data), "https://proxy.example.com/", provider)
+	})
+
+	It("should rewrite the URLs", func() {
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(result)).To(ContainSubstring(`"url":"https://proxy.example.com/jenkins/war/2.263/jenkins.war"`))
+		Expect(string(result)).To(ContainSubstring(`"url":"https://proxy.example.com/jenkins/plugins/git/4.4.5/git.hpi"`))
+		Expect(string(result)).To(ContainSubstring(`"url":"https://proxy.example.com/jenkins/plugins/ant/1.11/ant.hpi"`))
+	})
+
+	It("should keep the other fields", func() {
+		Expect(string(result)).To(ContainSubstring(`"size":1234567890123`))
+		Expect(string(result)).To(ContainSubstring(`"id":"default"`))
+		Expect(string(result)).To(ContainSubstring(`"digest":"a&b"`))
+	})
+
+	Context("with a provider", func() {
+		BeforeEach(func() {
+			provider = "internal"
+		})
+
+		It("should keep the provider", func() {
+			Expect(string(result)).To(ContainSubstring(`"url":"https://proxy.example.com/jenkins/plugins/git/4.4.5/git.hpi?provider=internal"`))
+		})
+	})
+
+	Context("invalid JSON", func() {
+		BeforeEach(func() {
+			data = "updateCenter.post({});"
+		})
+
+		It("should return an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("WrapUpdateCenterJSONP", func() {
+	It("should be a valid JSONP", func() {
+		Expect(string(server.WrapUpdateCenterJSONP([]byte("{}\n")))).To(Equal("updateCenter.post(\n{}\n);\n"))
+	})
+})
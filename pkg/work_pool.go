@@ -1,5 +1,7 @@
 package pkg
 
+import "sync"
+
 type Task struct {
 	Data     interface{}
 	TaskFunc TaskFunc
@@ -10,12 +12,18 @@ type TaskFunc = func(data interface{})
 type WorkPool struct {
 	TaskChannel chan Task
 	QuitChan    chan int
+
+	lock   sync.RWMutex
+	closed bool
+	done   chan struct{}
 }
 
 func (w *WorkPool) InitPool(size int) {
 	w.TaskChannel = make(chan Task, size)
 	w.QuitChan = make(chan int)
+	w.done = make(chan struct{})
 	go func() {
+		defer close(w.done)
 	DONE:
 		for {
 			select {
@@ -25,14 +33,42 @@ func (w *WorkPool) InitPool(size int) {
 				break DONE
 			}
 		}
+
+		// run the pending tasks before quit, they might be the download counts
+		for {
+			select {
+			case task := <-w.TaskChannel:
+				task.Run()
+			default:
+				return
+			}
+		}
 	}()
 }
 
+// ClosePool stops the pool after all the pending tasks are done
 func (w *WorkPool) ClosePool() {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	w.closed = true
+	w.lock.Unlock()
+
 	w.QuitChan <- 1
+	<-w.done
 }
 
+// AddTask queues the task, it runs immediately if the pool is closed
 func (w *WorkPool) AddTask(task Task) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		task.Run()
+		return
+	}
 	w.TaskChannel <- task
 }
 
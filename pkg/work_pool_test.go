@@ -3,6 +3,7 @@ package pkg_test
 import (
 	"fmt"
 	server "github.com/jenkins-zh/mirror-proxy/pkg"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -15,3 +16,28 @@ func TestWorkPool(t *testing.T) {
 	}})
 	time.Sleep(5 * time.Second)
 }
+
+func TestWorkPoolClose(t *testing.T) {
+	pool := &server.WorkPool{}
+	pool.InitPool(5)
+
+	var done int32
+	for i := 0; i < 20; i++ {
+		pool.AddTask(server.Task{TaskFunc: func(_ interface{}) {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&done, 1)
+		}})
+	}
+	pool.ClosePool()
+	if count := atomic.LoadInt32(&done); count != 20 {
+		t.Fatalf("expected all the 20 tasks are done before closing the pool, got %d", count)
+	}
+
+	pool.AddTask(server.Task{TaskFunc: func(_ interface{}) {
+		atomic.AddInt32(&done, 1)
+	}})
+	if count := atomic.LoadInt32(&done); count != 21 {
+		t.Fatalf("expected the task runs immediately after closing the pool, got %d", count)
+	}
+	pool.ClosePool()
+}